@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/monzo/typhon"
+)
+
+// adminClient sends requests to envoy's admin API. Each request is bounded by timeout, so that an admin port which
+// accepts connections but never responds can't hang us.
+//
+// As well as the usual http:// URLs, the admin API can be reached over a Unix socket with URLs such as
+// `unix:///var/run/envoy/admin.sock/server_info`.
+type adminClient struct {
+	timeout   time.Duration
+	svc       typhon.Service
+	headers   http.Header
+	tokenFile string
+
+	mu      sync.Mutex
+	sockets map[string]typhon.Service
+}
+
+func newAdminClient(cfg *config) (*adminClient, error) {
+	a := &adminClient{
+		timeout:   cfg.adminTimeout,
+		svc:       typhon.Client,
+		headers:   cfg.adminHeaders,
+		tokenFile: cfg.adminTokenFile,
+	}
+	if cfg.adminCAFile == "" && cfg.adminCertFile == "" {
+		return a, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.adminCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.adminCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ENVOY_ADMIN_CA_FILE: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ENVOY_ADMIN_CA_FILE: no certificates found in %s", cfg.adminCAFile)
+		}
+	}
+	if cfg.adminCertFile != "" {
+		// Load the certificate now so that mistakes are reported at startup, but reload it for each connection in
+		// case it has been rotated since.
+		if _, err := tls.LoadX509KeyPair(cfg.adminCertFile, cfg.adminKeyFile); err != nil {
+			return nil, fmt.Errorf("ENVOY_ADMIN_CERT_FILE: %w", err)
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(cfg.adminCertFile, cfg.adminKeyFile)
+			return &cert, err
+		}
+	}
+
+	a.svc = typhon.HttpService(&http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		IdleConnTimeout:     10 * time.Minute,
+		MaxIdleConnsPerHost: 10,
+	})
+	return a, nil
+}
+
+// request sends a request to url, routing it through a Unix socket if necessary.
+func (a *adminClient) request(ctx context.Context, method, url string) typhon.Response {
+	svc := a.svc
+	if isUnixURL(url) {
+		socket, p, err := splitUnixURL(url)
+		if err != nil {
+			return typhon.Response{Error: err}
+		}
+		svc = a.socketService(socket)
+		url = "http://localhost" + p
+	}
+
+	req := typhon.NewRequest(ctx, method, url, nil)
+	for name, values := range a.headers {
+		req.Header[name] = values
+	}
+	if a.tokenFile != "" {
+		// The token is read every time, as it may be rotated while we're running
+		token, err := ioutil.ReadFile(a.tokenFile)
+		if err != nil {
+			return typhon.Response{Error: err}
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return req.SendVia(svc).Response()
+}
+
+// socketService returns a service which sends requests over the Unix socket. Each socket gets its own transport so
+// that pooled connections aren't shared between sockets.
+func (a *adminClient) socketService(socket string) typhon.Service {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if svc, ok := a.sockets[socket]; ok {
+		return svc
+	}
+	if a.sockets == nil {
+		a.sockets = map[string]typhon.Service{}
+	}
+	svc := typhon.HttpService(&http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+		IdleConnTimeout: 10 * time.Minute,
+	})
+	a.sockets[socket] = svc
+	return svc
+}
+
+// splitUnixURL splits a URL such as `unix:///var/run/envoy/admin.sock/server_info?format=json` into the path of the
+// socket and the HTTP path. The socket is found by looking for the longest prefix of the path which is a socket on
+// disk, so it must exist already.
+func splitUnixURL(url string) (socket, rest string, err error) {
+	p := strings.TrimPrefix(url, "unix://")
+	query := ""
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p, query = p[:i], p[i:]
+	}
+
+	for socket = path.Clean(p); socket != "/" && socket != "."; socket = path.Dir(socket) {
+		if fi, err := os.Stat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			rest = strings.TrimPrefix(p, socket)
+			if rest == "" {
+				rest = "/"
+			}
+			return socket, rest + query, nil
+		}
+	}
+	return "", "", fmt.Errorf("no Unix socket found in %s", url)
+}
+
+// isUnixURL reports whether url refers to a Unix socket, which is necessarily on this machine.
+func isUnixURL(url string) bool {
+	return strings.HasPrefix(url, "unix://")
+}
+
+// get fetches url and decodes its JSON body into v.
+func (a *adminClient) get(ctx context.Context, url string, v interface{}) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	rsp := a.request(ctx, "GET", url)
+	return rsp.Decode(v)
+}
+
+// ok fetches url and checks that the response has a 2xx status.
+func (a *adminClient) ok(ctx context.Context, url string) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	rsp := a.request(ctx, "GET", url)
+	if rsp.Error != nil {
+		return rsp.Error
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, rsp.Status)
+	}
+	return nil
+}
+
+// post sends an empty POST to url and checks that the response has a 2xx status.
+func (a *adminClient) post(ctx context.Context, url string) error {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	rsp := a.request(ctx, "POST", url)
+	if rsp.Error != nil {
+		return rsp.Error
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, rsp.Status)
+	}
+	return nil
+}
+
+// refused reports whether connecting to the server at target is refused, or its Unix socket has gone, which means
+// that nothing is listening any more. Any other failure, such as a timeout, doesn't show that the server has exited.
+func (a *adminClient) refused(ctx context.Context, target string) bool {
+	ctx, cancel := withTimeout(ctx, a.timeout)
+	defer cancel()
+
+	network, address := "tcp", ""
+	if isUnixURL(target) {
+		socket, _, err := splitUnixURL(target)
+		if err != nil {
+			return true
+		}
+		network, address = "unix", socket
+	} else {
+		u, err := url.Parse(target)
+		if err != nil {
+			return false
+		}
+		address = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			address = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT)
+	}
+	conn.Close()
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitUnixURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envoy-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "admin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		url  string
+		rest string
+	}{
+		{"unix://" + socket, "/"},
+		{"unix://" + socket + "/", "/"},
+		{"unix://" + socket + "/server_info", "/server_info"},
+		{"unix://" + socket + "/stats?format=json&filter=a/b", "/stats?format=json&filter=a/b"},
+		{"unix://" + socket + "?format=json", "/?format=json"},
+	}
+	for _, tt := range tests {
+		gotSocket, rest, err := splitUnixURL(tt.url)
+		if err != nil {
+			t.Errorf("splitUnixURL(%q) returned error: %v", tt.url, err)
+			continue
+		}
+		if gotSocket != socket || rest != tt.rest {
+			t.Errorf("splitUnixURL(%q) = %q, %q, want %q, %q", tt.url, gotSocket, rest, socket, tt.rest)
+		}
+	}
+
+	for _, url := range []string{
+		"unix://" + filepath.Join(dir, "missing.sock") + "/server_info",
+		"unix://" + dir + "/server_info",
+		"unix://",
+	} {
+		if _, _, err := splitUnixURL(url); err == nil {
+			t.Errorf("splitUnixURL(%q) didn't return an error", url)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// requiredCluster is an upstream cluster which must have at least minHosts healthy hosts before the application starts.
+type requiredCluster struct {
+	name     string
+	minHosts int
+}
+
+// parseRequiredClusters parses a comma-separated list of cluster names, each optionally followed by `:N` to require
+// N healthy hosts rather than one.
+func parseRequiredClusters(s string) ([]requiredCluster, error) {
+	var clusters []requiredCluster
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		c := requiredCluster{name: entry, minHosts: 1}
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			n, err := strconv.Atoi(entry[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid host count in %q", entry)
+			}
+			c.name, c.minHosts = entry[:i], n
+		}
+		if c.name == "" {
+			return nil, fmt.Errorf("missing cluster name in %q", entry)
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// ClusterStatuses is the subset of envoy's `/clusters?format=json` output that we look at.
+type ClusterStatuses struct {
+	ClusterStatuses []struct {
+		Name         string `json:"name"`
+		HostStatuses []struct {
+			HealthStatus HostHealthStatus `json:"health_status"`
+		} `json:"host_statuses"`
+	} `json:"cluster_statuses"`
+}
+
+type HostHealthStatus struct {
+	FailedActiveHealthCheck bool   `json:"failed_active_health_check"`
+	FailedOutlierCheck      bool   `json:"failed_outlier_check"`
+	PendingDynamicRemoval   bool   `json:"pending_dynamic_removal"`
+	EDSHealthStatus         string `json:"eds_health_status"`
+}
+
+// healthy reports whether envoy would route to the host: it must pass active health checks and outlier detection,
+// and not have been marked unhealthy by the control plane.
+func (h HostHealthStatus) healthy() bool {
+	if h.FailedActiveHealthCheck || h.FailedOutlierCheck || h.PendingDynamicRemoval {
+		return false
+	}
+	switch h.EDSHealthStatus {
+	case "", "UNKNOWN", "HEALTHY":
+		return true
+	default:
+		return false
+	}
+}
+
+// clustersHealthy checks that each of the required clusters exists and has enough healthy hosts.
+func clustersHealthy(admin *adminClient, host string, required []requiredCluster) readinessCheck {
+	url := fmt.Sprintf("%s/clusters?format=json", host)
+	return func(ctx context.Context) error {
+		statuses := &ClusterStatuses{}
+		if err := admin.get(ctx, url, statuses); err != nil {
+			return err
+		}
+
+		healthy := map[string]int{}
+		for _, cluster := range statuses.ClusterStatuses {
+			n := 0
+			for _, h := range cluster.HostStatuses {
+				if h.HealthStatus.healthy() {
+					n++
+				}
+			}
+			healthy[cluster.Name] = n
+		}
+
+		for _, c := range required {
+			n, ok := healthy[c.name]
+			if !ok {
+				return fmt.Errorf("cluster %q not found", c.name)
+			}
+			if n < c.minHosts {
+				return fmt.Errorf("cluster %q has %d healthy hosts, want %d", c.name, n, c.minHosts)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRequiredClusters(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []requiredCluster
+	}{
+		{"", nil},
+		{"db", []requiredCluster{{name: "db", minHosts: 1}}},
+		{"db:3", []requiredCluster{{name: "db", minHosts: 3}}},
+		{" db:2 , cache ,", []requiredCluster{{name: "db", minHosts: 2}, {name: "cache", minHosts: 1}}},
+		// Only the last colon separates the host count, so names may contain colons
+		{"outbound|80||svc:2", []requiredCluster{{name: "outbound|80||svc", minHosts: 2}}},
+	}
+	for _, tt := range tests {
+		got, err := parseRequiredClusters(tt.s)
+		if err != nil {
+			t.Errorf("parseRequiredClusters(%q) returned error: %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRequiredClusters(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseRequiredClustersErrors(t *testing.T) {
+	for _, s := range []string{"db:", "db:0", "db:-1", "db:x", ":2", "a:b:c"} {
+		if _, err := parseRequiredClusters(s); err == nil {
+			t.Errorf("parseRequiredClusters(%q) didn't return an error", s)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What to do when envoy doesn't become ready within ENVOY_READY_TIMEOUT.
+const (
+	readyTimeoutExit  = "exit"
+	readyTimeoutStart = "start"
+)
+
+type config struct {
+	// Should be in format `http://127.0.0.1:9010`
+	adminAPI    string
+	hasAdminAPI bool
+	sidecarKind string
+
+	readyTimeout       time.Duration
+	readyTimeoutPolicy string
+	adminTimeout       time.Duration
+
+	adminCAFile    string
+	adminCertFile  string
+	adminKeyFile   string
+	adminHeaders   http.Header
+	adminTokenFile string
+
+	requiredClusters  []requiredCluster
+	requiredListeners []requiredListener
+	readyStats        []statPredicate
+	requiredSecrets   []string
+
+	probes []probe
+
+	// An envoy for us to start and supervise, rather than one in another container
+	envoyBinary       string
+	envoyBootstrap    string
+	envoyArgs         []string
+	envoyOutputPrefix string
+
+	watchdogInterval time.Duration
+	watchdogFailures int
+	watchdogAction   watchdogAction
+
+	drainTimeout time.Duration
+	killTimeout  time.Duration
+	killPolicy   *killPolicy
+	termDelay    time.Duration
+	killGrace    time.Duration
+	signals      *signalFilter
+	processGroup string
+	reapChildren bool
+	restart      string
+	maxRestarts  int
+	exitSignal   bool
+
+	// Processes to run instead of our command line, from PREFLIGHT_CONFIG
+	processes *processConfig
+
+	// Whether ENVOY_ADMIN_API is on this machine, if set explicitly with ENVOY_IS_LOCAL
+	isLocal    bool
+	hasIsLocal bool
+}
+
+func loadConfig() (*config, error) {
+	c := &config{}
+	c.adminAPI, c.hasAdminAPI = os.LookupEnv("ENVOY_ADMIN_API")
+
+	var err error
+	if c.readyTimeout, err = durationEnv("ENVOY_READY_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+
+	c.readyTimeoutPolicy = stringEnv("ENVOY_READY_TIMEOUT_POLICY", readyTimeoutExit)
+	switch c.readyTimeoutPolicy {
+	case readyTimeoutExit, readyTimeoutStart:
+	default:
+		return nil, fmt.Errorf("ENVOY_READY_TIMEOUT_POLICY: unknown policy %q", c.readyTimeoutPolicy)
+	}
+
+	if c.adminTimeout, err = durationEnv("ENVOY_ADMIN_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+
+	c.adminCAFile = os.Getenv("ENVOY_ADMIN_CA_FILE")
+	c.adminCertFile = os.Getenv("ENVOY_ADMIN_CERT_FILE")
+	c.adminKeyFile = os.Getenv("ENVOY_ADMIN_KEY_FILE")
+	if (c.adminCertFile == "") != (c.adminKeyFile == "") {
+		return nil, fmt.Errorf("ENVOY_ADMIN_CERT_FILE and ENVOY_ADMIN_KEY_FILE must be provided together")
+	}
+	if c.adminHeaders, err = parseHeaders(os.Getenv("ENVOY_ADMIN_HEADERS")); err != nil {
+		return nil, fmt.Errorf("ENVOY_ADMIN_HEADERS: %w", err)
+	}
+	c.adminTokenFile = os.Getenv("ENVOY_ADMIN_TOKEN_FILE")
+
+	if c.requiredClusters, err = parseRequiredClusters(os.Getenv("ENVOY_REQUIRED_CLUSTERS")); err != nil {
+		return nil, fmt.Errorf("ENVOY_REQUIRED_CLUSTERS: %w", err)
+	}
+
+	c.requiredListeners = parseRequiredListeners(os.Getenv("ENVOY_REQUIRED_LISTENERS"))
+
+	if c.readyStats, err = parseStatPredicates(os.Getenv("ENVOY_READY_STATS")); err != nil {
+		return nil, fmt.Errorf("ENVOY_READY_STATS: %w", err)
+	}
+
+	c.requiredSecrets = parseRequiredSecrets(os.Getenv("ENVOY_REQUIRED_SECRETS"))
+
+	if c.probes, err = parseProbes(os.Getenv("PREFLIGHT_WAIT_FOR")); err != nil {
+		return nil, fmt.Errorf("PREFLIGHT_WAIT_FOR: %w", err)
+	}
+
+	if c.watchdogInterval, err = durationEnv("ENVOY_WATCHDOG_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	c.watchdogFailures = 3
+	if v := os.Getenv("ENVOY_WATCHDOG_FAILURES"); v != "" {
+		if c.watchdogFailures, err = strconv.Atoi(v); err != nil || c.watchdogFailures < 1 {
+			return nil, fmt.Errorf("ENVOY_WATCHDOG_FAILURES: invalid count %q", v)
+		}
+	}
+	if c.watchdogAction, err = parseWatchdogAction(stringEnv("ENVOY_WATCHDOG_ACTION", watchdogLog)); err != nil {
+		return nil, fmt.Errorf("ENVOY_WATCHDOG_ACTION: %w", err)
+	}
+
+	if c.drainTimeout, err = durationEnv("ENVOY_DRAIN_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+
+	if c.killTimeout, err = durationEnv("ENVOY_KILL_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+
+	killOn, ok := os.LookupEnv("ENVOY_KILL_ON")
+	switch {
+	case ok && (os.Getenv("NEVER_KILL_ENVOY") == "true" || os.Getenv("ALWAYS_KILL_ENVOY") == "true"):
+		return nil, fmt.Errorf("ENVOY_KILL_ON can't be combined with NEVER_KILL_ENVOY or ALWAYS_KILL_ENVOY")
+	case ok:
+	case os.Getenv("NEVER_KILL_ENVOY") == "true":
+		killOn = "never"
+	case os.Getenv("ALWAYS_KILL_ENVOY") == "true":
+		killOn = "any"
+	default:
+		killOn = "success"
+	}
+	if c.killPolicy, err = parseKillPolicy(killOn); err != nil {
+		return nil, fmt.Errorf("ENVOY_KILL_ON: %w", err)
+	}
+
+	if c.termDelay, err = durationEnv("PREFLIGHT_TERM_DELAY", 0); err != nil {
+		return nil, err
+	}
+
+	if c.killGrace, err = durationEnv("PREFLIGHT_KILL_GRACE", 0); err != nil {
+		return nil, err
+	}
+
+	c.signals, err = newSignalFilter(os.Getenv("PREFLIGHT_FORWARD_SIGNALS"), os.Getenv("PREFLIGHT_IGNORE_SIGNALS"),
+		os.Getenv("PREFLIGHT_SIGNAL_MAP"))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.processGroup, err = parseProcessGroup(stringEnv("PREFLIGHT_PROCESS_GROUP", processGroupNone)); err != nil {
+		return nil, fmt.Errorf("PREFLIGHT_PROCESS_GROUP: %w", err)
+	}
+
+	c.reapChildren = os.Getenv("PREFLIGHT_INIT") == "true"
+	c.exitSignal = os.Getenv("PREFLIGHT_EXIT_SIGNAL") == "true"
+
+	if c.restart, err = parseRestartPolicy(stringEnv("PREFLIGHT_RESTART", restartNever)); err != nil {
+		return nil, fmt.Errorf("PREFLIGHT_RESTART: %w", err)
+	}
+	if v := os.Getenv("PREFLIGHT_MAX_RESTARTS"); v != "" {
+		if c.maxRestarts, err = strconv.Atoi(v); err != nil || c.maxRestarts < 0 {
+			return nil, fmt.Errorf("PREFLIGHT_MAX_RESTARTS: invalid count %q", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ENVOY_IS_LOCAL"); ok && v != "" {
+		if c.isLocal, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("ENVOY_IS_LOCAL: invalid boolean %q", v)
+		}
+		c.hasIsLocal = true
+	}
+
+	if path := os.Getenv("PREFLIGHT_CONFIG"); path != "" {
+		if c.processes, err = loadProcessConfig(path); err != nil {
+			return nil, fmt.Errorf("PREFLIGHT_CONFIG: %w", err)
+		}
+		if len(os.Args) > 1 {
+			return nil, fmt.Errorf("PREFLIGHT_CONFIG can't be combined with a command to run")
+		}
+		if c.restart != restartNever {
+			return nil, fmt.Errorf("PREFLIGHT_RESTART can't be combined with PREFLIGHT_CONFIG")
+		}
+	}
+
+	c.envoyBinary = os.Getenv("ENVOY_BINARY")
+	c.envoyBootstrap = os.Getenv("ENVOY_BOOTSTRAP")
+	c.envoyArgs = strings.Fields(os.Getenv("ENVOY_ARGS"))
+	c.envoyOutputPrefix = os.Getenv("ENVOY_OUTPUT_PREFIX")
+	if c.envoyBinary == "" && (c.envoyBootstrap != "" || len(c.envoyArgs) > 0 || c.envoyOutputPrefix != "") {
+		return nil, fmt.Errorf("ENVOY_BOOTSTRAP, ENVOY_ARGS and ENVOY_OUTPUT_PREFIX need ENVOY_BINARY")
+	}
+	if c.envoyBinary != "" && !c.hasAdminAPI {
+		return nil, fmt.Errorf("ENVOY_BINARY needs ENVOY_ADMIN_API, to tell when envoy is ready")
+	}
+
+	c.sidecarKind = stringEnv("SIDECAR_KIND", sidecarEnvoy)
+	switch c.sidecarKind {
+	case sidecarEnvoy:
+	case sidecarIstio, sidecarLinkerd, sidecarHTTP:
+		if len(c.requiredClusters) > 0 || len(c.requiredListeners) > 0 || len(c.readyStats) > 0 || len(c.requiredSecrets) > 0 ||
+			c.drainTimeout > 0 {
+			return nil, fmt.Errorf("SIDECAR_KIND: envoy readiness options can't be used with %s", c.sidecarKind)
+		}
+	default:
+		return nil, fmt.Errorf("SIDECAR_KIND: unknown kind %q", c.sidecarKind)
+	}
+
+	return c, nil
+}
+
+// parseHeaders parses a comma-separated list of `Name:value` headers.
+func parseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid header %q", entry)
+		}
+		headers.Add(strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:]))
+	}
+	return headers, nil
+}
+
+func stringEnv(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// durationEnv parses a Go duration such as `30s` or `2m`. A bare number is taken to be seconds.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		d, err = time.ParseDuration(v + "s")
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", name, v)
+	}
+	return d, nil
+}
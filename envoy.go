@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// envoyProcess is an envoy which we started ourselves, for environments without sidecar containers. It never outlives
+// us.
+type envoyProcess struct {
+	proc   *os.Process
+	exited chan struct{}     // closed once envoy has exited
+	status exitStatus        // how envoy exited, once exited is closed
+	copied []<-chan struct{} // closed once envoy's output has been copied, with ENVOY_OUTPUT_PREFIX
+}
+
+// startEnvoy runs ENVOY_BINARY with ENVOY_BOOTSTRAP and ENVOY_ARGS, prefixing each line of its output with
+// ENVOY_OUTPUT_PREFIX if that's set.
+func startEnvoy(cfg *config, reap *reaper) (*envoyProcess, error) {
+	binary, err := exec.LookPath(cfg.envoyBinary)
+	if err != nil {
+		return nil, err
+	}
+	argv := []string{cfg.envoyBinary}
+	if cfg.envoyBootstrap != "" {
+		argv = append(argv, "-c", cfg.envoyBootstrap)
+	}
+	argv = append(argv, cfg.envoyArgs...)
+
+	stdout, stderr := os.Stdout, os.Stderr
+	var copiedOut, copiedErr <-chan struct{}
+	if cfg.envoyOutputPrefix != "" {
+		if stdout, copiedOut, err = prefixOutput(os.Stdout, cfg.envoyOutputPrefix); err != nil {
+			return nil, err
+		}
+		if stderr, copiedErr, err = prefixOutput(os.Stderr, cfg.envoyOutputPrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	// In its own process group, so that a Ctrl-C meant for the application doesn't reach envoy: we shut it down
+	// once the application has exited.
+	proc, done, err := reap.start(binary, argv, &os.ProcAttr{
+		Files: []*os.File{os.Stdin, stdout, stderr},
+		Sys:   &syscall.SysProcAttr{Setpgid: true},
+	})
+	if cfg.envoyOutputPrefix != "" {
+		// Only envoy should hold the write ends now, so that the copies stop when it exits
+		stdout.Close()
+		stderr.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &envoyProcess{proc: proc, exited: make(chan struct{})}
+	if cfg.envoyOutputPrefix != "" {
+		e.copied = []<-chan struct{}{copiedOut, copiedErr}
+	}
+	go func() {
+		e.status = newExitStatus(<-done)
+		close(e.exited)
+	}()
+	return e, nil
+}
+
+// prefixOutput returns a pipe whose output is copied line by line to w, with prefix in front of each line. The
+// channel is closed once everything written to the pipe has been copied.
+func prefixOutput(w io.Writer, prefix string) (*os.File, <-chan struct{}, error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer r.Close()
+
+		br := bufio.NewReaderSize(r, 64*1024)
+		var buf []byte
+		start := true
+		for {
+			// A line too long for the buffer is copied in pieces, with the prefix only in front of the first
+			line, err := br.ReadSlice('\n')
+			if len(line) > 0 {
+				buf = buf[:0]
+				if start {
+					buf = append(buf, prefix...)
+				}
+				buf = append(buf, line...)
+				start = line[len(line)-1] == '\n'
+				if !start && err != nil && err != bufio.ErrBufferFull {
+					buf = append(buf, '\n')
+				}
+				w.Write(buf)
+			}
+			if err != nil && err != bufio.ErrBufferFull {
+				return
+			}
+		}
+	}()
+	return pw, done, nil
+}
+
+// stop terminates envoy if it's still running, killing it if it hasn't exited within timeout, and waits for it to
+// exit. A zero timeout waits forever.
+func (e *envoyProcess) stop(timeout time.Duration) {
+	defer e.flush()
+
+	select {
+	case <-e.exited:
+		return
+	default:
+	}
+
+	e.proc.Signal(syscall.SIGTERM)
+	if timeout > 0 {
+		select {
+		case <-e.exited:
+			return
+		case <-time.After(timeout):
+			log.Printf("envoy still running %s after SIGTERM, killing it", timeout)
+			e.proc.Signal(syscall.SIGKILL)
+		}
+	}
+	<-e.exited
+}
+
+// flush waits a moment for the last of envoy's output to be copied, as that's usually why it exited. Anything else
+// still holding the pipes open mustn't keep us waiting for long.
+func (e *envoyProcess) flush() {
+	timeout := time.After(time.Second)
+	for _, copied := range e.copied {
+		select {
+		case <-copied:
+		case <-timeout:
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// killPolicy decides from how the application exited whether envoy should be told to exit. It's parsed from a
+// comma-separated list of exit codes (`0`), ranges of exit codes (`64-78`), signal names (`SIGTERM`) and the keywords
+// `success`, `failure`, `any` and `never`.
+type killPolicy struct {
+	codes   [][2]int
+	signals map[syscall.Signal]bool
+	success bool
+	failure bool
+	never   bool // envoy is left running even if we're stopped before the application starts
+}
+
+func parseKillPolicy(s string) (*killPolicy, error) {
+	p := &killPolicy{signals: map[syscall.Signal]bool{}}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		switch strings.ToLower(entry) {
+		case "":
+			continue
+		case "success":
+			p.success = true
+			continue
+		case "failure":
+			p.failure = true
+			continue
+		case "any":
+			p.success, p.failure = true, true
+			continue
+		case "never":
+			p.never = true
+			continue
+		}
+
+		if strings.HasPrefix(strings.ToUpper(entry), "SIG") {
+			sig, err := parseSignal(entry)
+			if err != nil {
+				return nil, err
+			}
+			p.signals[sig] = true
+			continue
+		}
+
+		lo, hi := entry, entry
+		if i := strings.Index(entry, "-"); i > 0 {
+			lo, hi = entry[:i], entry[i+1:]
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || from < 0 || to > 255 || from > to {
+			return nil, fmt.Errorf("invalid exit code or keyword %q", entry)
+		}
+		p.codes = append(p.codes, [2]int{from, to})
+	}
+
+	if p.never && (p.success || p.failure || len(p.codes) > 0 || len(p.signals) > 0) {
+		return nil, fmt.Errorf("never can't be combined with other values")
+	}
+	return p, nil
+}
+
+// matches reports whether the policy covers s. A process killed by a signal reports the exit code 128+signal, as does a
+// shell whose child was killed, so signals and the codes above 128 match either way.
+func (p *killPolicy) matches(s exitStatus) bool {
+	if s.success() && p.success || !s.success() && p.failure {
+		return true
+	}
+	if s.code > 128 && p.signals[syscall.Signal(s.code-128)] {
+		return true
+	}
+	for _, r := range p.codes {
+		if s.code >= r[0] && s.code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
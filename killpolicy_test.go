@@ -0,0 +1,71 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestKillPolicyMatches(t *testing.T) {
+	var (
+		success = exitStatus{code: 0}
+		code3   = exitStatus{code: 3}
+		code137 = exitStatus{code: 137}
+		term    = signalledStatus(syscall.SIGTERM, false)
+		kill    = signalledStatus(syscall.SIGKILL, false)
+	)
+
+	tests := []struct {
+		policy  string
+		matches []exitStatus
+		misses  []exitStatus
+	}{
+		{"success", []exitStatus{success}, []exitStatus{code3, term}},
+		{"failure", []exitStatus{code3, term}, []exitStatus{success}},
+		{"any", []exitStatus{success, code3, term}, nil},
+		{"never", nil, []exitStatus{success, code3, term}},
+		{"0,3", []exitStatus{success, code3}, []exitStatus{code137}},
+		{"1-5", []exitStatus{code3}, []exitStatus{success, code137}},
+		{"SIGTERM", []exitStatus{term}, []exitStatus{kill, success}},
+		{"sigterm", []exitStatus{term}, nil},
+		// A signal exit has a code of 128+signal, which a shell also reports when its child is killed
+		{"137", []exitStatus{code137, kill}, []exitStatus{term}},
+		{"128-255", []exitStatus{code137, term, kill}, []exitStatus{code3}},
+		{"SIGKILL", []exitStatus{kill, code137}, []exitStatus{term, code3}},
+		{"success, SIGINT ,SIGTERM", []exitStatus{success, term}, []exitStatus{code3, kill}},
+	}
+	for _, tt := range tests {
+		p, err := parseKillPolicy(tt.policy)
+		if err != nil {
+			t.Errorf("parseKillPolicy(%q) returned error: %v", tt.policy, err)
+			continue
+		}
+		for _, s := range tt.matches {
+			if !p.matches(s) {
+				t.Errorf("policy %q doesn't match %s", tt.policy, s)
+			}
+		}
+		for _, s := range tt.misses {
+			if p.matches(s) {
+				t.Errorf("policy %q matches %s", tt.policy, s)
+			}
+		}
+	}
+}
+
+func TestParseKillPolicyErrors(t *testing.T) {
+	for _, policy := range []string{
+		"never,success",
+		"never,3",
+		"SIGTERM,never",
+		"sometimes",
+		"SIGNOPE",
+		"256",
+		"-1",
+		"5-3",
+		"1-",
+	} {
+		if _, err := parseKillPolicy(policy); err == nil {
+			t.Errorf("parseKillPolicy(%q) didn't return an error", policy)
+		}
+	}
+}
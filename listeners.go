@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// requiredListener is either the name of an envoy listener, or a bare `host:port` address which only has to accept
+// TCP connections.
+type requiredListener struct {
+	name    string
+	address string
+}
+
+func parseRequiredListeners(s string) []requiredListener {
+	var listeners []requiredListener
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(entry); err == nil {
+			if _, err := strconv.Atoi(port); err == nil {
+				listeners = append(listeners, requiredListener{address: entry})
+				continue
+			}
+		}
+		listeners = append(listeners, requiredListener{name: entry})
+	}
+	return listeners
+}
+
+// ListenerStatuses is the subset of envoy's `/listeners?format=json` output that we look at.
+type ListenerStatuses struct {
+	ListenerStatuses []struct {
+		Name         string `json:"name"`
+		LocalAddress struct {
+			SocketAddress struct {
+				Address   string `json:"address"`
+				PortValue int    `json:"port_value"`
+			} `json:"socket_address"`
+		} `json:"local_address"`
+	} `json:"listener_statuses"`
+}
+
+// DynamicListeners is the subset of envoy's `/config_dump?resource=dynamic_listeners` output that we look at.
+type DynamicListeners struct {
+	Configs []struct {
+		Name         string          `json:"name"`
+		ActiveState  json.RawMessage `json:"active_state"`
+		WarmingState json.RawMessage `json:"warming_state"`
+	} `json:"configs"`
+}
+
+// listenersActive checks that each required listener has finished warming and accepts connections.
+func listenersActive(admin *adminClient, host string, required []requiredListener) readinessCheck {
+	listenersURL := fmt.Sprintf("%s/listeners?format=json", host)
+	dumpURL := fmt.Sprintf("%s/config_dump?resource=dynamic_listeners", host)
+
+	// Listeners bound to a wildcard address are reached through the same host as the admin interface
+	dialHost := "127.0.0.1"
+	if u, err := url.Parse(host); err == nil && u.Hostname() != "" {
+		dialHost = u.Hostname()
+	}
+
+	return func(ctx context.Context) error {
+		var named []string
+		for _, l := range required {
+			if l.name != "" {
+				named = append(named, l.name)
+			} else if err := tcpProbe(l.address, admin.timeout)(ctx); err != nil {
+				return err
+			}
+		}
+		if len(named) == 0 {
+			return nil
+		}
+
+		statuses := &ListenerStatuses{}
+		if err := admin.get(ctx, listenersURL, statuses); err != nil {
+			return err
+		}
+		dump := &DynamicListeners{}
+		if err := admin.get(ctx, dumpURL, dump); err != nil {
+			return err
+		}
+
+		for _, name := range named {
+			// Static listeners don't appear in the dump of dynamic ones, and are active as soon as envoy is.
+			for _, l := range dump.Configs {
+				if l.Name != name {
+					continue
+				}
+				if isSet(l.WarmingState) {
+					return fmt.Errorf("listener %q is still warming", name)
+				}
+				if !isSet(l.ActiveState) {
+					return fmt.Errorf("listener %q is not active", name)
+				}
+			}
+
+			address := ""
+			for _, l := range statuses.ListenerStatuses {
+				if l.Name == name {
+					sa := l.LocalAddress.SocketAddress
+					address = net.JoinHostPort(sa.Address, strconv.Itoa(sa.PortValue))
+					if ip := net.ParseIP(sa.Address); ip != nil && ip.IsUnspecified() {
+						address = net.JoinHostPort(dialHost, strconv.Itoa(sa.PortValue))
+					}
+				}
+			}
+			if address == "" {
+				return fmt.Errorf("listener %q not found", name)
+			}
+			if err := tcpProbe(address, admin.timeout)(ctx); err != nil {
+				return fmt.Errorf("listener %q: %w", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func isSet(m json.RawMessage) bool {
+	return len(m) > 0 && string(m) != "null"
+}
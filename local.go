@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// isLocal decides whether the sidecar at adminAPI runs on this machine, and so whether it should be shut down when the
+// application exits. The reason is returned so that the decision can be logged.
+func isLocal(adminAPI string) (bool, string) {
+	if isUnixURL(adminAPI) {
+		return true, "the admin API is a Unix socket"
+	}
+
+	u, err := url.Parse(adminAPI)
+	if err != nil || u.Hostname() == "" {
+		return false, fmt.Sprintf("couldn't parse a host from %q", adminAPI)
+	}
+	host := u.Hostname()
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return false, fmt.Sprintf("couldn't resolve %s: %v", host, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	ifaceAddrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		switch {
+		case ip.IsLoopback():
+			return true, fmt.Sprintf("%s is a loopback address", ip)
+		case ip.IsUnspecified():
+			return true, fmt.Sprintf("%s is the unspecified address", ip)
+		}
+		for _, a := range ifaceAddrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return true, fmt.Sprintf("%s belongs to a local interface", ip)
+			}
+		}
+	}
+	return false, fmt.Sprintf("%s is not an address of this machine", host)
+}
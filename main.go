@@ -4,102 +4,177 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"os"
-	"os/exec"
-	"os/signal"
-	"strings"
+	"time"
 
 	"github.com/cenk/backoff"
-	"github.com/monzo/typhon"
+)
+
+// Exit codes for failures of envoy-preflight itself, taken from sysexits.h so that they stay clear of the
+// 128+signal range.
+const (
+	exitUnavailable    = 69 // EX_UNAVAILABLE
+	exitEnvoyRunning   = 75 // EX_TEMPFAIL
+	exitEnvoyUnhealthy = 76 // EX_PROTOCOL
+	exitConfig         = 78 // EX_CONFIG
 )
 
 type ServerInfo struct {
 	State string `json:"state"`
 }
 
+func init() {
+	log.SetPrefix("envoy-preflight: ")
+}
+
 func main() {
-	// Should be in format `http://127.0.0.1:9010`
-	host, ok := os.LookupEnv("ENVOY_ADMIN_API")
-	if ok && os.Getenv("START_WITHOUT_ENVOY") != "true" {
-		block(host)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("invalid configuration: %v", err)
+		os.Exit(exitConfig)
+	}
+
+	admin, err := newAdminClient(cfg)
+	if err != nil {
+		log.Printf("invalid configuration: %v", err)
+		os.Exit(exitConfig)
+	}
+	checker := newReadinessChecker(cfg, admin)
+
+	host, ok := cfg.adminAPI, cfg.hasAdminAPI
+
+	// Only a local envoy is shut down when the application exits
+	local, reason := cfg.isLocal, "ENVOY_IS_LOCAL is set"
+	switch {
+	case cfg.envoyBinary != "":
+		local, reason = true, "envoy-preflight starts it"
+	case ok && !cfg.hasIsLocal:
+		local, reason = isLocal(host)
+	}
+	if ok {
+		log.Printf("treating envoy as local=%t: %s", local, reason)
 	}
 
 	killAPI, killOk := os.LookupEnv("ENVOY_KILL_API")
 	if !killOk {
-		killAPI = fmt.Sprintf("%s/quitquitquit", host)
+		killAPI = checker.KillURL()
 	}
 
-	if len(os.Args) < 2 {
-		return
+	// As an init process, reap zombies. This starts before envoy or anything else does, so that every process we start
+	// is waited for however soon it exits.
+	var reap *reaper
+	if cfg.reapChildren {
+		if os.Getpid() != 1 {
+			if err := becomeSubreaper(); err != nil {
+				log.Printf("WARNING: can't become a subreaper, orphaned processes won't be reaped: %v", err)
+			}
+		}
+		reap = newReaper()
+		reap.run()
 	}
 
-	binary, err := exec.LookPath(os.Args[1])
-	if err != nil {
-		panic(err)
+	// The processes to run, either from PREFLIGHT_CONFIG or the command line
+	var specs []processSpec
+	exitCodeFrom := ""
+	switch {
+	case cfg.processes != nil:
+		specs, exitCodeFrom = cfg.processes.Processes, cfg.processes.ExitCodeFrom
+	case len(os.Args) > 1:
+		specs = []processSpec{{Name: "the application", Command: os.Args[1:]}}
 	}
 
-	var proc *os.Process
+	s := newSupervisor(cfg, admin, checker, ok && local, killAPI, reap, specs, exitCodeFrom)
+	s.handleSignals()
+	if cfg.envoyBinary != "" {
+		s.startEnvoy()
+	}
 
-	// Pass signals to the child process
-	go func() {
-		stop := make(chan os.Signal, 2)
-		signal.Notify(stop)
-		for sig := range stop {
-			if proc != nil {
-				proc.Signal(sig)
-			} else {
-				// Signal received before the process even started. Let's just exit.
-				os.Exit(1)
-			}
+	var ready readinessCheck
+	if ok && os.Getenv("START_WITHOUT_ENVOY") != "true" {
+		ready = checker.Ready
+		s.waitFor("envoy", ready)
+	}
+	if len(cfg.probes) > 0 {
+		checks := make([]readinessCheck, len(cfg.probes))
+		for i, p := range cfg.probes {
+			checks[i] = p.check(cfg.adminTimeout, reap)
 		}
-	}()
-
-	proc, err = os.StartProcess(binary, os.Args[1:], &os.ProcAttr{
-		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
-	})
-	if err != nil {
-		panic(err)
+		s.waitFor("PREFLIGHT_WAIT_FOR", checks...)
+	}
+	if s.ctx.Err() != nil {
+		s.interrupted()
 	}
 
-	state, err := proc.Wait()
-	if err != nil {
-		panic(err)
+	if len(specs) == 0 {
+		if cfg.envoyBinary != "" {
+			s.idle()
+		}
+		return
 	}
 
-	exitCode := state.ExitCode()
+	if ok && cfg.watchdogInterval > 0 {
+		live := func(ctx context.Context) error {
+			return admin.ok(ctx, checker.StatusURL())
+		}
+		if cfg.sidecarKind == sidecarEnvoy {
+			live = serverLive(admin, host)
+		}
+		go watchdog(cfg.watchdogInterval, cfg.watchdogFailures, live, func(error) {
+			s.watchdogFailed(cfg.watchdogAction)
+		})
+	}
 
-	switch {
-	case !ok:
-		// We don't have an ENVOY_ADMIN_API env var, do nothing
-	case !strings.Contains(host, "127.0.0.1") && !strings.Contains(host, "localhost"):
-		// Envoy is not local; do nothing
-	case os.Getenv("NEVER_KILL_ENVOY") == "true":
-		// We're configured never to kill envoy, do nothing
-	case os.Getenv("ALWAYS_KILL_ENVOY") == "true", exitCode == 0:
-		// Either we had a clean exit, or we are configured to kill envoy anyway
-		_ = typhon.NewRequest(context.Background(), "POST", killAPI, nil).Send().Response()
-	}
-
-	os.Exit(exitCode)
+	s.supervise(ready)
 }
 
-func block(host string) {
-	if os.Getenv("START_WITHOUT_ENVOY") == "true" {
-		return
-	}
+// A readinessCheck returns nil once some condition that the application depends on holds.
+type readinessCheck func(ctx context.Context) error
 
-	url := fmt.Sprintf("%s/server_info", host)
+// wait blocks until every check passes or ctx is cancelled. It returns false if we should give up and exit instead.
+func wait(ctx context.Context, cfg *config, what string, checks ...readinessCheck) bool {
+	err := block(ctx, cfg.readyTimeout, checks...)
+	switch {
+	case err == nil, ctx.Err() != nil:
+	case cfg.readyTimeoutPolicy != readyTimeoutStart:
+		log.Printf("giving up waiting for %s: %v", what, err)
+		return false
+	default:
+		log.Printf("WARNING: starting without %s, the application may not work: %v", what, err)
+	}
+	return true
+}
 
+// block polls until every check passes. With a zero timeout it waits forever; in practice k8s will kill the pod if we
+// take too long.
+func block(ctx context.Context, timeout time.Duration, checks ...readinessCheck) error {
 	b := backoff.NewExponentialBackOff()
-	// We wait forever for envoy to start. In practice k8s will kill the pod if we take too long.
-	b.MaxElapsedTime = 0
+	b.MaxElapsedTime = timeout
 
-	_ = backoff.Retry(func() error {
-		rsp := typhon.NewRequest(context.Background(), "GET", url, nil).Send().Response()
+	err := backoff.Retry(func() error {
+		for _, check := range checks {
+			if err := check(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, backoff.WithContext(b, ctx))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("not ready after %s: %w", timeout, err)
+	}
+	return nil
+}
 
+// serverLive checks that envoy reports itself as live, which implies it has loaded its initial configuration.
+func serverLive(admin *adminClient, host string) readinessCheck {
+	url := fmt.Sprintf("%s/server_info", host)
+	return func(ctx context.Context) error {
 		info := &ServerInfo{}
 
-		err := rsp.Decode(info)
+		err := admin.get(ctx, url, info)
 		if err != nil {
 			return err
 		}
@@ -109,5 +184,5 @@ func block(host string) {
 		}
 
 		return nil
-	}, b)
+	}
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/monzo/typhon"
+)
+
+// A probe is one of the things to wait for from PREFLIGHT_WAIT_FOR.
+type probe struct {
+	entry  string   // as it was written, to describe the probe
+	kind   string   // tcp, http, file or exec
+	target string   // the address, URL or path to check
+	status int      // the status an HTTP probe wants, or 0 for any 2xx
+	args   []string // the command an exec probe runs
+}
+
+// parseProbes parses a comma-separated list of things to wait for besides the sidecar:
+//
+//	tcp://127.0.0.1:5432                      accepts TCP connections
+//	http://127.0.0.1:8200/v1/sys/health#200   returns the status after the #, or any 2xx without one
+//	file:///vault/secrets/db                  exists
+//	exec:/bin/check --flag                    exits with status 0
+//
+// A comma which is part of an entry, such as in a command's arguments, is escaped as `\,`.
+func parseProbes(s string) ([]probe, error) {
+	var probes []probe
+	for _, entry := range splitEscaped(s) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Commands are taken as they are, rather than as URLs which would have to be escaped
+		if strings.HasPrefix(entry, "exec:") {
+			args := strings.Fields(strings.TrimPrefix(entry, "exec:"))
+			if len(args) == 0 {
+				return nil, fmt.Errorf("missing command in %q", entry)
+			}
+			probes = append(probes, probe{entry: entry, kind: "exec", args: args})
+			continue
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, err
+		}
+		p := probe{entry: entry, kind: u.Scheme}
+		switch u.Scheme {
+		case "tcp":
+			if u.Host == "" {
+				return nil, fmt.Errorf("missing address in %q", entry)
+			}
+			p.target = u.Host
+		case "http", "https":
+			if u.Fragment != "" {
+				if p.status, err = strconv.Atoi(u.Fragment); err != nil {
+					return nil, fmt.Errorf("invalid status in %q", entry)
+				}
+				u.Fragment = ""
+			}
+			p.kind, p.target = "http", u.String()
+		case "file":
+			if u.Path == "" {
+				return nil, fmt.Errorf("missing path in %q", entry)
+			}
+			p.target = u.Path
+		default:
+			return nil, fmt.Errorf("unknown probe %q", entry)
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+// check returns a readinessCheck for the probe, which gives up on an individual attempt after timeout. Commands are
+// started through reap.
+func (p probe) check(timeout time.Duration, reap *reaper) readinessCheck {
+	var check readinessCheck
+	switch p.kind {
+	case "tcp":
+		check = tcpProbe(p.target, timeout)
+	case "http":
+		check = httpProbe(p.target, p.status, timeout)
+	case "file":
+		check = fileProbe(p.target)
+	case "exec":
+		check = execProbe(p.args, timeout, reap)
+	}
+	return describe(p.entry, check)
+}
+
+// splitEscaped splits s on commas, except those escaped as `\,`.
+func splitEscaped(s string) []string {
+	var entries []string
+	var entry strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == ',':
+			entry.WriteByte(',')
+			i++
+		case s[i] == ',':
+			entries = append(entries, entry.String())
+			entry.Reset()
+		default:
+			entry.WriteByte(s[i])
+		}
+	}
+	return append(entries, entry.String())
+}
+
+// describe prefixes errors from check with what it was checking.
+func describe(what string, check readinessCheck) readinessCheck {
+	return func(ctx context.Context) error {
+		if err := check(ctx); err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		return nil
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func tcpProbe(address string, timeout time.Duration) readinessCheck {
+	return func(ctx context.Context) error {
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+func httpProbe(url string, status int, timeout time.Duration) readinessCheck {
+	return func(ctx context.Context) error {
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
+		rsp := typhon.NewRequest(ctx, "GET", url, nil).Send().Response()
+		if rsp.Error != nil {
+			return rsp.Error
+		}
+		rsp.Body.Close()
+		switch {
+		case status != 0 && rsp.StatusCode != status:
+			return fmt.Errorf("got status %d, want %d", rsp.StatusCode, status)
+		case status == 0 && (rsp.StatusCode < 200 || rsp.StatusCode > 299):
+			return fmt.Errorf("got status %d", rsp.StatusCode)
+		}
+		return nil
+	}
+}
+
+func fileProbe(path string) readinessCheck {
+	return func(ctx context.Context) error {
+		_, err := os.Stat(path)
+		return err
+	}
+}
+
+// execProbe runs a command, which passes if it exits with status 0. It's started through reap so that the reaper,
+// if there is one, doesn't take its status first.
+func execProbe(args []string, timeout time.Duration, reap *reaper) readinessCheck {
+	return func(ctx context.Context) error {
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+
+		binary, err := exec.LookPath(args[0])
+		if err != nil {
+			return err
+		}
+		stdin, err := os.Open(os.DevNull)
+		if err != nil {
+			return err
+		}
+		defer stdin.Close()
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		proc, done, err := reap.start(binary, args, &os.ProcAttr{Files: []*os.File{stdin, w, w}})
+		w.Close()
+		if err != nil {
+			return err
+		}
+
+		output := make(chan []byte, 1)
+		go func() {
+			out, _ := ioutil.ReadAll(r)
+			output <- out
+		}()
+
+		var ws syscall.WaitStatus
+		select {
+		case ws = <-done:
+		case <-ctx.Done():
+			proc.Kill()
+			<-done
+			return ctx.Err()
+		}
+		status := newExitStatus(ws)
+		if status.success() {
+			return nil
+		}
+
+		// Anything the command left running may still hold the pipe open, so don't wait past the timeout for it
+		var out []byte
+		select {
+		case out = <-output:
+		case <-ctx.Done():
+		}
+		if out := strings.TrimSpace(string(out)); out != "" {
+			return fmt.Errorf("%s: %s", status, out)
+		}
+		return errors.New(status.String())
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitEscaped(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{""}},
+		{"a", []string{"a"}},
+		{"a,b", []string{"a", "b"}},
+		{"a,,b,", []string{"a", "", "b", ""}},
+		{`exec:check a\,b,tcp://x:1`, []string{"exec:check a,b", "tcp://x:1"}},
+		// Backslashes which don't escape a comma are left alone
+		{`a\b,c\`, []string{`a\b`, `c\`}},
+	}
+	for _, tt := range tests {
+		if got := splitEscaped(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitEscaped(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseProbes(t *testing.T) {
+	f, err := ioutil.TempFile("", "envoy-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	tests := []struct {
+		s  string
+		ok bool
+	}{
+		{"file://" + f.Name(), true},
+		{"file:///nonexistent/envoy-preflight", false},
+		{"exec:true", true},
+		{"exec:false", false},
+		// Commands aren't URLs, so % and escaped commas are passed on as they are
+		{"exec:test 100% = 100%", true},
+		{`exec:test a\,b = a\,b`, true},
+		{"exec:test a = b", false},
+		{" exec:true , file://" + f.Name() + " ,", true},
+		{"exec:true,exec:false", false},
+	}
+	for _, tt := range tests {
+		probes, err := parseProbes(tt.s)
+		if err != nil {
+			t.Errorf("parseProbes(%q) returned error: %v", tt.s, err)
+			continue
+		}
+		if len(probes) == 0 {
+			t.Errorf("parseProbes(%q) returned no probes", tt.s)
+			continue
+		}
+		for _, p := range probes {
+			if err = p.check(time.Second, nil)(context.Background()); err != nil {
+				break
+			}
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("probing %q returned %v, want ok=%t", tt.s, err, tt.ok)
+		}
+	}
+}
+
+func TestParseProbesErrors(t *testing.T) {
+	for _, s := range []string{
+		"exec:",
+		"exec: ",
+		"tcp://",
+		"file://",
+		"http://localhost:8200/health#ok",
+		"ftp://localhost",
+		"localhost:5432",
+		"http://%zz",
+	} {
+		if _, err := parseProbes(s); err == nil {
+			t.Errorf("parseProbes(%q) didn't return an error", s)
+		}
+	}
+}
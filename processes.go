@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+)
+
+// processConfig is read from PREFLIGHT_CONFIG, to run several processes rather than the single command on our
+// command line:
+//
+//	{
+//	  "processes": [
+//	    {"name": "server", "command": ["/app/server", "--port", "8080"]},
+//	    {"name": "exporter", "command": ["/app/exporter"], "required": false}
+//	  ],
+//	  "exit_code_from": "server"
+//	}
+type processConfig struct {
+	Processes    []processSpec `json:"processes"`
+	ExitCodeFrom string        `json:"exit_code_from"`
+}
+
+// processSpec is a process for us to run. When a required process exits, the others are terminated.
+type processSpec struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Required *bool    `json:"required"`
+}
+
+func (p processSpec) required() bool {
+	return p.Required == nil || *p.Required
+}
+
+func loadProcessConfig(path string) (*processConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &processConfig{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(c.Processes) == 0 {
+		return nil, fmt.Errorf("%s: no processes", path)
+	}
+	names := map[string]bool{}
+	for _, p := range c.Processes {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("%s: a process has no name", path)
+		case names[p.Name]:
+			return nil, fmt.Errorf("%s: more than one process is called %q", path, p.Name)
+		case len(p.Command) == 0:
+			return nil, fmt.Errorf("%s: process %q has no command", path, p.Name)
+		}
+		// Check that the command exists now, rather than after waiting for envoy
+		if _, err := exec.LookPath(p.Command[0]); err != nil {
+			return nil, fmt.Errorf("%s: process %q: %w", path, p.Name, err)
+		}
+		names[p.Name] = true
+	}
+	if c.ExitCodeFrom != "" && !names[c.ExitCodeFrom] {
+		return nil, fmt.Errorf("%s: exit_code_from names unknown process %q", path, c.ExitCodeFrom)
+	}
+	return c, nil
+}
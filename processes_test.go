@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProcessConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envoy-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	write := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{
+		"processes": [
+			{"name": "server", "command": ["sh", "-c", "exit 0"]},
+			{"name": "exporter", "command": ["true"], "required": false}
+		],
+		"exit_code_from": "server"
+	}`)
+	c, err := loadProcessConfig(path)
+	if err != nil {
+		t.Fatalf("loadProcessConfig returned error: %v", err)
+	}
+	if len(c.Processes) != 2 || c.ExitCodeFrom != "server" {
+		t.Fatalf("loadProcessConfig = %+v", c)
+	}
+	if !c.Processes[0].required() || c.Processes[1].required() {
+		t.Errorf("processes are required=%t,%t, want true,false", c.Processes[0].required(), c.Processes[1].required())
+	}
+
+	for _, config := range []string{
+		`not json`,
+		`{}`,
+		`{"processes": []}`,
+		`{"processes": [{"command": ["true"]}]}`,
+		`{"processes": [{"name": "a", "command": ["true"]}, {"name": "a", "command": ["true"]}]}`,
+		`{"processes": [{"name": "a"}]}`,
+		`{"processes": [{"name": "a", "command": []}]}`,
+		`{"processes": [{"name": "a", "command": ["/nonexistent/envoy-preflight"]}]}`,
+		`{"processes": [{"name": "a", "command": ["true"]}], "exit_code_from": "b"}`,
+	} {
+		write(config)
+		if _, err := loadProcessConfig(path); err == nil {
+			t.Errorf("loadProcessConfig(%s) didn't return an error", config)
+		}
+	}
+
+	if _, err := loadProcessConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("loadProcessConfig didn't return an error for a missing file")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Where the application is started, set with PREFLIGHT_PROCESS_GROUP.
+const (
+	processGroupNone    = "none"    // in our own process group
+	processGroupGroup   = "group"   // in a new process group
+	processGroupSession = "session" // in a new session, and so a new process group
+)
+
+func parseProcessGroup(s string) (string, error) {
+	switch s {
+	case processGroupNone, processGroupGroup, processGroupSession:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown mode %q", s)
+}
+
+// sysProcAttr returns the attributes to start the application with so that it leads its own group, if it should.
+func sysProcAttr(mode string) *syscall.SysProcAttr {
+	switch mode {
+	case processGroupGroup:
+		return &syscall.SysProcAttr{Setpgid: true}
+	case processGroupSession:
+		return &syscall.SysProcAttr{Setsid: true}
+	}
+	return nil
+}
+
+// signalProcess sends sig to proc, or to every process in its group if it was started as a group leader. This means
+// that applications run by a shell script get the signal, not just the shell.
+func signalProcess(proc *os.Process, mode string, sig syscall.Signal) error {
+	if mode == processGroupNone {
+		return proc.Signal(sig)
+	}
+	return syscall.Kill(-proc.Pid, sig)
+}
+
+// killStragglers kills anything left in the application's process group once it has exited, reporting whether there
+// was anything to kill.
+func killStragglers(proc *os.Process, mode string) bool {
+	if mode == processGroupNone {
+		return false
+	}
+	running := groupRunning(proc.Pid)
+	syscall.Kill(-proc.Pid, syscall.SIGKILL)
+	return running
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// groupRunning reports whether any process in the group pgid is still running. Zombies, which have exited but not yet
+// been reaped, don't count: signalling the group succeeds while they're left in it, although there's nothing to kill.
+func groupRunning(pgid int) bool {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false
+	}
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile("/proc/" + dir.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name is in brackets and may contain anything, so the fields are counted from its end:
+		// `pid (comm) state ppid pgrp ...`
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 3 || fields[0] == "Z" || fields[0] == "X" {
+			continue
+		}
+		if fields[2] == strconv.Itoa(pgid) {
+			return true
+		}
+	}
+	return false
+}
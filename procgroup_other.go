@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "syscall"
+
+// groupRunning reports whether any process in the group pgid may still be running. Without /proc, zombies can't be
+// told apart from running processes.
+func groupRunning(pgid int) bool {
+	return syscall.Kill(-pgid, 0) == nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reaper waits for every child process as it exits, including orphans which have been reparented to us because we're
+// PID 1 or a subreaper. Otherwise these would be left as zombies. The processes it starts have their wait statuses
+// passed back; anything else is discarded.
+//
+// While it's running nothing else may wait for child processes, as the reaper may take their status first.
+type reaper struct {
+	mu      sync.Mutex
+	waiting map[int]chan syscall.WaitStatus
+}
+
+func newReaper() *reaper {
+	return &reaper{waiting: map[int]chan syscall.WaitStatus{}}
+}
+
+// run starts reaping. Processes can be started beforehand, but they won't be waited for until now.
+func (r *reaper) run() {
+	children := make(chan os.Signal, 1)
+	signal.Notify(children, syscall.SIGCHLD)
+	go func() {
+		r.reap()
+		for range children {
+			r.reap()
+		}
+	}()
+}
+
+// start starts a process, returning a channel which receives its wait status once it has exited. Without a reaper,
+// the process is waited for on its own.
+func (r *reaper) start(binary string, argv []string, attr *os.ProcAttr) (*os.Process, <-chan syscall.WaitStatus, error) {
+	done := make(chan syscall.WaitStatus, 1)
+	if r == nil {
+		proc, err := os.StartProcess(binary, argv, attr)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			state, err := proc.Wait()
+			if err != nil {
+				panic(err)
+			}
+			done <- state.Sys().(syscall.WaitStatus)
+		}()
+		return proc, done, nil
+	}
+
+	// Hold the lock until the process is registered, in case it exits straight away
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	proc, err := os.StartProcess(binary, argv, attr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.waiting[proc.Pid] = done
+	return proc, done, nil
+}
+
+// reap waits for every child which has exited. Signals are coalesced, so one SIGCHLD may stand for many children.
+func (r *reaper) reap() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+		if done, ok := r.waiting[pid]; ok {
+			done <- ws
+			delete(r.waiting, pid)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// When the application is restarted after it exits, set with PREFLIGHT_RESTART.
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+func parseRestartPolicy(s string) (string, error) {
+	switch s {
+	case restartNever, restartOnFailure, restartAlways:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown policy %q", s)
+}
+
+// shouldRestart reports whether policy restarts an application which exited with status, after it has already been
+// restarted restarts times. A maxRestarts of zero means there is no limit.
+func shouldRestart(policy string, maxRestarts, restarts int, status exitStatus) bool {
+	if maxRestarts > 0 && restarts >= maxRestarts {
+		return false
+	}
+	switch policy {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return !status.success()
+	}
+	return false
+}
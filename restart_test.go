@@ -0,0 +1,54 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestShouldRestart(t *testing.T) {
+	var (
+		success = exitStatus{code: 0}
+		failure = exitStatus{code: 1}
+		killed  = signalledStatus(syscall.SIGKILL, false)
+	)
+
+	tests := []struct {
+		policy      string
+		maxRestarts int
+		restarts    int
+		status      exitStatus
+		want        bool
+	}{
+		{restartNever, 0, 0, failure, false},
+		{restartNever, 0, 0, success, false},
+		{restartOnFailure, 0, 0, failure, true},
+		{restartOnFailure, 0, 0, killed, true},
+		{restartOnFailure, 0, 0, success, false},
+		{restartAlways, 0, 0, success, true},
+		{restartAlways, 0, 0, failure, true},
+		// No limit
+		{restartAlways, 0, 1000, failure, true},
+		{restartOnFailure, 3, 2, failure, true},
+		{restartOnFailure, 3, 3, failure, false},
+		{restartAlways, 1, 1, success, false},
+	}
+	for _, tt := range tests {
+		got := shouldRestart(tt.policy, tt.maxRestarts, tt.restarts, tt.status)
+		if got != tt.want {
+			t.Errorf("shouldRestart(%q, %d, %d, %s) = %t, want %t", tt.policy, tt.maxRestarts, tt.restarts, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, s := range []string{restartNever, restartOnFailure, restartAlways} {
+		if got, err := parseRestartPolicy(s); err != nil || got != s {
+			t.Errorf("parseRestartPolicy(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "Always", "on_failure", "unless-stopped"} {
+		if _, err := parseRestartPolicy(s); err == nil {
+			t.Errorf("parseRestartPolicy(%q) didn't return an error", s)
+		}
+	}
+}
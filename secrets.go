@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func parseRequiredSecrets(s string) []string {
+	var secrets []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			secrets = append(secrets, name)
+		}
+	}
+	return secrets
+}
+
+// DataSource is how envoy represents a certificate: either inline or as a file on disk. Inline bytes are base64
+// encoded in JSON, which encoding/json decodes for us.
+type DataSource struct {
+	Filename     string `json:"filename"`
+	InlineBytes  []byte `json:"inline_bytes"`
+	InlineString string `json:"inline_string"`
+}
+
+// ActiveSecrets is the subset of envoy's `/config_dump?resource=dynamic_active_secrets` output that we look at.
+// Private keys are redacted by envoy, and we don't need them anyway.
+type ActiveSecrets struct {
+	Configs []struct {
+		Name   string `json:"name"`
+		Secret *struct {
+			TLSCertificate *struct {
+				CertificateChain DataSource `json:"certificate_chain"`
+			} `json:"tls_certificate"`
+			ValidationContext *struct {
+				TrustedCA DataSource `json:"trusted_ca"`
+			} `json:"validation_context"`
+		} `json:"secret"`
+	} `json:"configs"`
+}
+
+// Certs is the subset of envoy's `/certs` output that we look at.
+type Certs struct {
+	Certificates []struct {
+		CACert    []CertDetails `json:"ca_cert"`
+		CertChain []CertDetails `json:"cert_chain"`
+	} `json:"certificates"`
+}
+
+type CertDetails struct {
+	Path           string    `json:"path"`
+	ExpirationTime time.Time `json:"expiration_time"`
+}
+
+// secretsValid checks that each named SDS secret has been received, and that its certificates are currently valid.
+func secretsValid(admin *adminClient, host string, required []string) readinessCheck {
+	dumpURL := fmt.Sprintf("%s/config_dump?resource=dynamic_active_secrets", host)
+	certsURL := fmt.Sprintf("%s/certs", host)
+
+	return func(ctx context.Context) error {
+		dump := &ActiveSecrets{}
+		if err := admin.get(ctx, dumpURL, dump); err != nil {
+			return err
+		}
+
+		// Certificates which envoy reads from disk aren't in the config dump, so we rely on what it reports in /certs
+		var certs *Certs
+		fileValid := func(path string) error {
+			if certs == nil {
+				certs = &Certs{}
+				if err := admin.get(ctx, certsURL, certs); err != nil {
+					return err
+				}
+			}
+			for _, c := range certs.Certificates {
+				for _, d := range append(c.CertChain, c.CACert...) {
+					if d.Path == path {
+						if time.Now().After(d.ExpirationTime) {
+							return fmt.Errorf("certificate %s expired at %s", path, d.ExpirationTime)
+						}
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("certificate %s not loaded yet", path)
+		}
+
+		for _, name := range required {
+			found := false
+			for _, c := range dump.Configs {
+				if c.Name != name || c.Secret == nil {
+					continue
+				}
+				found = true
+
+				var err error
+				switch s := c.Secret; {
+				case s.TLSCertificate != nil && s.TLSCertificate.CertificateChain.Filename != "":
+					err = fileValid(s.TLSCertificate.CertificateChain.Filename)
+				case s.TLSCertificate != nil:
+					err = pemValid(s.TLSCertificate.CertificateChain, false)
+				case s.ValidationContext != nil && s.ValidationContext.TrustedCA.Filename != "":
+					err = fileValid(s.ValidationContext.TrustedCA.Filename)
+				case s.ValidationContext != nil:
+					err = pemValid(s.ValidationContext.TrustedCA, true)
+				}
+				if err != nil {
+					return fmt.Errorf("secret %q: %w", name, err)
+				}
+			}
+			if !found {
+				return fmt.Errorf("secret %q not received yet", name)
+			}
+		}
+		return nil
+	}
+}
+
+// pemValid checks an inline PEM bundle. The first certificate of a chain is the leaf, which must be valid; a CA bundle
+// only needs one valid certificate.
+func pemValid(ds DataSource, bundle bool) error {
+	data := ds.InlineBytes
+	if len(data) == 0 {
+		data = []byte(ds.InlineString)
+	}
+
+	now := time.Now()
+	var lastErr error
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		switch {
+		case err != nil:
+			lastErr = err
+		case now.Before(cert.NotBefore):
+			lastErr = fmt.Errorf("certificate %q not valid until %s", cert.Subject, cert.NotBefore)
+		case now.After(cert.NotAfter):
+			lastErr = fmt.Errorf("certificate %q expired at %s", cert.Subject, cert.NotAfter)
+		default:
+			lastErr = nil
+		}
+		if !bundle || lastErr == nil {
+			return lastErr
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no certificates")
+	}
+	return lastErr
+}
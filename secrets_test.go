@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate in PEM which is valid from notBefore until notAfter.
+func testCert(t *testing.T, name string, notBefore, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestPEMValid(t *testing.T) {
+	now := time.Now()
+	var (
+		valid   = testCert(t, "valid", now.Add(-time.Hour), now.Add(time.Hour))
+		expired = testCert(t, "expired", now.Add(-2*time.Hour), now.Add(-time.Hour))
+		future  = testCert(t, "future", now.Add(time.Hour), now.Add(2*time.Hour))
+		key     = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")}))
+		garbage = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}))
+	)
+
+	tests := []struct {
+		name   string
+		pem    string
+		bundle bool
+		ok     bool
+	}{
+		{"valid leaf", valid, false, true},
+		{"expired leaf", expired, false, false},
+		{"leaf not valid yet", future, false, false},
+		// Only the leaf of a chain matters
+		{"valid leaf with expired CA", valid + expired, false, true},
+		{"expired leaf with valid CA", expired + valid, false, false},
+		// A CA bundle only needs one certificate which is valid
+		{"bundle with a valid certificate", expired + future + valid, true, true},
+		{"bundle with no valid certificate", expired + future, true, false},
+		{"other blocks are skipped", key + valid, false, true},
+		{"unparseable certificate", garbage, false, false},
+		{"no certificates", key, false, false},
+		{"empty", "", true, false},
+	}
+	for _, tt := range tests {
+		for _, ds := range []DataSource{{InlineString: tt.pem}, {InlineBytes: []byte(tt.pem)}} {
+			err := pemValid(ds, tt.bundle)
+			if (err == nil) != tt.ok {
+				t.Errorf("%s: pemValid returned %v, want ok=%t", tt.name, err, tt.ok)
+			}
+		}
+	}
+}
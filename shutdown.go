@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cenk/backoff"
+)
+
+// A healthFailer is a sidecar which can be told to fail its health checks, so that it's taken out of load balancing.
+type healthFailer interface {
+	FailHealthCheck(ctx context.Context) error
+}
+
+// A drainer is a sidecar which can stop taking on new work and wait for its existing connections to finish.
+type drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// shutdown tells the sidecar to exit, first draining it for up to drainTimeout if it supports that. The kill request is
+// retried until it succeeds or connections to the sidecar are refused, and then we wait for them to be refused; if
+// either hasn't happened within killTimeout an error is returned. A sidecar which refuses connections to begin with is
+// taken to have exited already, but one which is merely slow or erroring is still told to exit.
+func shutdown(ctx context.Context, admin *adminClient, checker ReadinessChecker, killAPI string, drainTimeout, killTimeout time.Duration) error {
+	// The kill endpoint may belong to a different process from the status endpoint, e.g. with istio, so we can only
+	// tell that the sidecar has gone once nothing is listening any more.
+	status := checker.StatusURL()
+	if admin.refused(ctx, status) {
+		log.Printf("envoy is refusing connections, so it has already exited")
+		return nil
+	}
+
+	if d, ok := checker.(drainer); ok && drainTimeout > 0 {
+		drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+		err := d.Drain(drainCtx)
+		cancel()
+		if err != nil {
+			log.Printf("envoy did not finish draining, shutting it down anyway: %v", err)
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx, killTimeout)
+	defer cancel()
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+
+	gone := false
+	err := backoff.RetryNotify(func() error {
+		err := admin.post(ctx, killAPI)
+		if err != nil && admin.refused(ctx, status) {
+			// Exited by itself, or was already on its way out; either way there's nothing left to tell
+			gone = true
+			return nil
+		}
+		return err
+	}, backoff.WithContext(b, ctx), func(err error, next time.Duration) {
+		log.Printf("failed to tell envoy to exit, retrying in %s: %v", next.Round(time.Millisecond), err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tell envoy to exit: %w", err)
+	}
+	if gone {
+		return nil
+	}
+
+	err = backoff.Retry(func() error {
+		if !admin.refused(ctx, status) {
+			return fmt.Errorf("%s is still accepting connections", status)
+		}
+		return nil
+	}, backoff.WithContext(b, ctx))
+	if err != nil {
+		return fmt.Errorf("envoy did not exit: %w", err)
+	}
+	return nil
+}
+
+const drainStatsFilter = `^(server\.total_connections|http\..*\.downstream_rq_active)$`
+
+// Drain fails envoy's health check so that it's taken out of load balancing, gracefully drains its listeners, and then
+// waits until it has no connections or requests left.
+func (c *envoyChecker) Drain(ctx context.Context) error {
+	if err := c.FailHealthCheck(ctx); err != nil {
+		return err
+	}
+	if err := c.admin.post(ctx, fmt.Sprintf("%s/drain_listeners?graceful", c.host)); err != nil {
+		return err
+	}
+
+	b := backoff.NewConstantBackOff(time.Second)
+	return backoff.Retry(func() error {
+		values, err := fetchStatsMatching(ctx, c.admin, c.host, drainStatsFilter)
+		if err != nil {
+			return err
+		}
+
+		active := 0.0
+		for name, v := range values {
+			// Requests to the admin interface, including our own, don't hold up the drain
+			if !strings.HasPrefix(name, "http.admin.") {
+				active += v
+			}
+		}
+		if active > 0 {
+			return fmt.Errorf("%v connections and requests still active", active)
+		}
+		return nil
+	}, backoff.WithContext(b, ctx))
+}
+
+func (c *envoyChecker) FailHealthCheck(ctx context.Context) error {
+	return c.admin.post(ctx, fmt.Sprintf("%s/healthcheck/fail", c.host))
+}
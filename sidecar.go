@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// The kinds of sidecar proxy that we know how to wait for, selected with SIDECAR_KIND.
+const (
+	sidecarEnvoy   = "envoy"
+	sidecarIstio   = "istio"
+	sidecarLinkerd = "linkerd"
+	sidecarHTTP    = "http"
+)
+
+// A ReadinessChecker knows when a particular kind of sidecar is ready, and how to tell it to shut down.
+type ReadinessChecker interface {
+	// Ready returns nil once the sidecar can proxy traffic for the application.
+	Ready(ctx context.Context) error
+	// KillURL is the endpoint which is POSTed to in order to make the sidecar exit, or empty if there isn't one.
+	KillURL() string
+	// StatusURL is an endpoint which responds for as long as the sidecar is running.
+	StatusURL() string
+}
+
+func newReadinessChecker(cfg *config, admin *adminClient) ReadinessChecker {
+	host := cfg.adminAPI
+	switch cfg.sidecarKind {
+	case sidecarIstio:
+		return &istioChecker{admin: admin, host: host}
+	case sidecarLinkerd:
+		return &linkerdChecker{admin: admin, host: host}
+	case sidecarHTTP:
+		return &httpChecker{admin: admin, url: host}
+	}
+
+	checks := []readinessCheck{serverLive(admin, host)}
+	if len(cfg.requiredClusters) > 0 {
+		checks = append(checks, clustersHealthy(admin, host, cfg.requiredClusters))
+	}
+	if len(cfg.requiredListeners) > 0 {
+		checks = append(checks, listenersActive(admin, host, cfg.requiredListeners))
+	}
+	if len(cfg.readyStats) > 0 {
+		checks = append(checks, statsHold(admin, host, cfg.readyStats))
+	}
+	if len(cfg.requiredSecrets) > 0 {
+		checks = append(checks, secretsValid(admin, host, cfg.requiredSecrets))
+	}
+	return &envoyChecker{admin: admin, host: host, checks: checks}
+}
+
+// envoyChecker talks to envoy's admin interface, _e.g._ `http://127.0.0.1:9010`. This also covers Consul Connect,
+// which uses envoy as its sidecar.
+type envoyChecker struct {
+	admin  *adminClient
+	host   string
+	checks []readinessCheck
+}
+
+func (c *envoyChecker) Ready(ctx context.Context) error {
+	for _, check := range c.checks {
+		if err := check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *envoyChecker) KillURL() string {
+	return fmt.Sprintf("%s/quitquitquit", c.host)
+}
+
+func (c *envoyChecker) StatusURL() string {
+	return fmt.Sprintf("%s/server_info", c.host)
+}
+
+// istioChecker talks to pilot-agent's status port, _e.g._ `http://127.0.0.1:15021`. pilot-agent only reports ready
+// once envoy has received its configuration, but it serves quitquitquit from its admin port, 15020, instead.
+type istioChecker struct {
+	admin *adminClient
+	host  string
+}
+
+func (c *istioChecker) Ready(ctx context.Context) error {
+	return c.admin.ok(ctx, c.StatusURL())
+}
+
+func (c *istioChecker) StatusURL() string {
+	return fmt.Sprintf("%s/healthz/ready", c.host)
+}
+
+func (c *istioChecker) KillURL() string {
+	u, err := url.Parse(c.host)
+	if err != nil {
+		return ""
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), "15020")
+	return fmt.Sprintf("%s/quitquitquit", u)
+}
+
+// linkerdChecker talks to linkerd-proxy's admin port, _e.g._ `http://127.0.0.1:4191`. Its shutdown endpoint has to be
+// enabled with `config.linkerd.io/enable-shutdown-endpoint`.
+type linkerdChecker struct {
+	admin *adminClient
+	host  string
+}
+
+func (c *linkerdChecker) Ready(ctx context.Context) error {
+	return c.admin.ok(ctx, c.StatusURL())
+}
+
+func (c *linkerdChecker) StatusURL() string {
+	return fmt.Sprintf("%s/ready", c.host)
+}
+
+func (c *linkerdChecker) KillURL() string {
+	return fmt.Sprintf("%s/shutdown", c.host)
+}
+
+// httpChecker polls an arbitrary URL until it returns a 2xx response. There's no standard way to shut such a sidecar
+// down, so ENVOY_KILL_API has to be provided for that.
+type httpChecker struct {
+	admin *adminClient
+	url   string
+}
+
+func (c *httpChecker) Ready(ctx context.Context) error {
+	return c.admin.ok(ctx, c.url)
+}
+
+func (c *httpChecker) KillURL() string {
+	return ""
+}
+
+func (c *httpChecker) StatusURL() string {
+	return c.url
+}
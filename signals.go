@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Signals that can be named in configuration, with or without the SIG prefix.
+var signalNames = map[string]syscall.Signal{
+	"ABRT":   syscall.SIGABRT,
+	"ALRM":   syscall.SIGALRM,
+	"BUS":    syscall.SIGBUS,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"FPE":    syscall.SIGFPE,
+	"HUP":    syscall.SIGHUP,
+	"ILL":    syscall.SIGILL,
+	"INT":    syscall.SIGINT,
+	"IO":     syscall.SIGIO,
+	"KILL":   syscall.SIGKILL,
+	"PIPE":   syscall.SIGPIPE,
+	"PROF":   syscall.SIGPROF,
+	"QUIT":   syscall.SIGQUIT,
+	"SEGV":   syscall.SIGSEGV,
+	"STOP":   syscall.SIGSTOP,
+	"SYS":    syscall.SIGSYS,
+	"TERM":   syscall.SIGTERM,
+	"TRAP":   syscall.SIGTRAP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"VTALRM": syscall.SIGVTALRM,
+	"WINCH":  syscall.SIGWINCH,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+}
+
+// parseSignal parses a signal name such as `SIGTERM` or `term`, or a signal number.
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 && n < 65 {
+		return syscall.Signal(n), nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
+
+// signalName returns the conventional name of sig, _e.g._ `SIGTERM`.
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// signalFilter decides which of the signals we receive are passed on to the application, and as which signal.
+type signalFilter struct {
+	allow map[syscall.Signal]bool // If set, only these signals are forwarded
+	deny  map[syscall.Signal]bool
+	remap map[syscall.Signal]syscall.Signal
+}
+
+// SIGCHLD tells us about our own children, and SIGURG is sent by the Go runtime to preempt goroutines, so neither is
+// meant for the application.
+var neverForwarded = []syscall.Signal{syscall.SIGCHLD, syscall.SIGURG}
+
+// stopsStartup reports whether sig stops us if it arrives before the application has started. This doesn't depend on
+// which signals would be passed on to the application, as we have to be stoppable either way.
+func stopsStartup(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP:
+		return true
+	}
+	return false
+}
+
+func newSignalFilter(allow, deny, remap string) (*signalFilter, error) {
+	if allow != "" && deny != "" {
+		return nil, fmt.Errorf("PREFLIGHT_FORWARD_SIGNALS and PREFLIGHT_IGNORE_SIGNALS can't be combined")
+	}
+
+	f := &signalFilter{remap: map[syscall.Signal]syscall.Signal{}}
+	var err error
+	if allow != "" {
+		if f.allow, err = parseSignalSet(allow); err != nil {
+			return nil, fmt.Errorf("PREFLIGHT_FORWARD_SIGNALS: %w", err)
+		}
+	}
+	if f.deny, err = parseSignalSet(deny); err != nil {
+		return nil, fmt.Errorf("PREFLIGHT_IGNORE_SIGNALS: %w", err)
+	}
+	for _, sig := range neverForwarded {
+		f.deny[sig] = true
+	}
+
+	for _, entry := range strings.Split(remap, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("PREFLIGHT_SIGNAL_MAP: expected FROM:TO in %q", entry)
+		}
+		from, err := parseSignal(entry[:i])
+		if err != nil {
+			return nil, fmt.Errorf("PREFLIGHT_SIGNAL_MAP: %w", err)
+		}
+		to, err := parseSignal(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("PREFLIGHT_SIGNAL_MAP: %w", err)
+		}
+		f.remap[from] = to
+	}
+	return f, nil
+}
+
+func parseSignalSet(s string) (map[syscall.Signal]bool, error) {
+	set := map[syscall.Signal]bool{}
+	for _, name := range strings.Split(s, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		set[sig] = true
+	}
+	return set, nil
+}
+
+// allowed reports whether sig should be passed on to the application.
+func (f *signalFilter) allowed(sig syscall.Signal) bool {
+	if f.deny[sig] {
+		return false
+	}
+	return f.allow == nil || f.allow[sig]
+}
+
+// mapped returns the signal that the application should receive in place of sig.
+func (f *signalFilter) mapped(sig syscall.Signal) syscall.Signal {
+	if to, ok := f.remap[sig]; ok {
+		return to
+	}
+	return sig
+}
+
+// raise kills us with sig, so that our parent sees that we were killed by it rather than exiting. It returns if sig
+// doesn't kill us, as happens to PID 1 or when its default action is to be ignored.
+func raise(sig syscall.Signal) {
+	signal.Reset(sig)
+	if syscall.Kill(os.Getpid(), sig) == nil {
+		// The signal may be delivered to another thread, so give it a moment to land
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		s    string
+		want syscall.Signal
+	}{
+		{"SIGTERM", syscall.SIGTERM},
+		{"TERM", syscall.SIGTERM},
+		{"sigterm", syscall.SIGTERM},
+		{" SIGHUP ", syscall.SIGHUP},
+		{"9", syscall.SIGKILL},
+		{"64", syscall.Signal(64)},
+	}
+	for _, tt := range tests {
+		got, err := parseSignal(tt.s)
+		if err != nil {
+			t.Errorf("parseSignal(%q) returned error: %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSignal(%q) = %s, want %s", tt.s, signalName(got), signalName(tt.want))
+		}
+	}
+
+	for _, s := range []string{"", "SIG", "SIGNOPE", "0", "65", "-1"} {
+		if _, err := parseSignal(s); err == nil {
+			t.Errorf("parseSignal(%q) didn't return an error", s)
+		}
+	}
+}
+
+func TestSignalFilter(t *testing.T) {
+	tests := []struct {
+		allow, deny, remap string
+		forwarded          []syscall.Signal
+		ignored            []syscall.Signal
+		mapped             map[syscall.Signal]syscall.Signal
+	}{
+		{
+			forwarded: []syscall.Signal{syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1},
+			// These are only meant for us, whatever is configured
+			ignored: []syscall.Signal{syscall.SIGCHLD, syscall.SIGURG},
+		},
+		{
+			allow:     "SIGTERM,INT",
+			forwarded: []syscall.Signal{syscall.SIGTERM, syscall.SIGINT},
+			ignored:   []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1},
+		},
+		{
+			allow:   "SIGCHLD",
+			ignored: []syscall.Signal{syscall.SIGCHLD, syscall.SIGTERM},
+		},
+		{
+			deny:      "SIGHUP, SIGUSR1",
+			forwarded: []syscall.Signal{syscall.SIGTERM, syscall.SIGUSR2},
+			ignored:   []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGCHLD},
+		},
+		{
+			remap:     "SIGTERM:SIGQUIT, HUP:USR1",
+			forwarded: []syscall.Signal{syscall.SIGTERM, syscall.SIGHUP},
+			mapped: map[syscall.Signal]syscall.Signal{
+				syscall.SIGTERM: syscall.SIGQUIT,
+				syscall.SIGHUP:  syscall.SIGUSR1,
+				syscall.SIGINT:  syscall.SIGINT,
+			},
+		},
+	}
+	for _, tt := range tests {
+		f, err := newSignalFilter(tt.allow, tt.deny, tt.remap)
+		if err != nil {
+			t.Errorf("newSignalFilter(%q, %q, %q) returned error: %v", tt.allow, tt.deny, tt.remap, err)
+			continue
+		}
+		for _, sig := range tt.forwarded {
+			if !f.allowed(sig) {
+				t.Errorf("newSignalFilter(%q, %q, %q) doesn't forward %s", tt.allow, tt.deny, tt.remap, signalName(sig))
+			}
+		}
+		for _, sig := range tt.ignored {
+			if f.allowed(sig) {
+				t.Errorf("newSignalFilter(%q, %q, %q) forwards %s", tt.allow, tt.deny, tt.remap, signalName(sig))
+			}
+		}
+		for from, to := range tt.mapped {
+			if got := f.mapped(from); got != to {
+				t.Errorf("newSignalFilter(%q, %q, %q) maps %s to %s, want %s", tt.allow, tt.deny, tt.remap, signalName(from), signalName(got), signalName(to))
+			}
+		}
+	}
+}
+
+func TestNewSignalFilterErrors(t *testing.T) {
+	tests := []struct {
+		allow, deny, remap string
+	}{
+		{allow: "SIGTERM", deny: "SIGHUP"},
+		{allow: "SIGNOPE"},
+		{deny: "SIGTERM,x"},
+		{remap: "SIGTERM"},
+		{remap: "SIGTERM:SIGNOPE"},
+		{remap: "SIGNOPE:SIGTERM"},
+	}
+	for _, tt := range tests {
+		if _, err := newSignalFilter(tt.allow, tt.deny, tt.remap); err == nil {
+			t.Errorf("newSignalFilter(%q, %q, %q) didn't return an error", tt.allow, tt.deny, tt.remap)
+		}
+	}
+}
+
+func TestStopsStartup(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP} {
+		if !stopsStartup(sig) {
+			t.Errorf("%s doesn't stop startup", signalName(sig))
+		}
+	}
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGCHLD, syscall.SIGWINCH, syscall.SIGURG} {
+		if stopsStartup(sig) {
+			t.Errorf("%s stops startup", signalName(sig))
+		}
+	}
+}
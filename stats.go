@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// statPredicate is a condition on a single envoy stat, _e.g._ `cluster_manager.warming_clusters==0`. A predicate with
+// no operator only requires the stat to have been recorded.
+type statPredicate struct {
+	stat  string
+	op    string
+	value float64
+}
+
+// Longer operators come first so that `>=` isn't parsed as `>`
+var statOperators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+func parseStatPredicates(s string) ([]statPredicate, error) {
+	var predicates []statPredicate
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		p := statPredicate{stat: entry}
+		for _, op := range statOperators {
+			if i := strings.Index(entry, op); i >= 0 {
+				v, err := strconv.ParseFloat(strings.TrimSpace(entry[i+len(op):]), 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value in %q", entry)
+				}
+				p = statPredicate{stat: strings.TrimSpace(entry[:i]), op: op, value: v}
+				break
+			}
+		}
+		if p.stat == "" || strings.ContainsAny(p.stat, "=!<>") {
+			return nil, fmt.Errorf("invalid predicate %q", entry)
+		}
+		predicates = append(predicates, p)
+	}
+	return predicates, nil
+}
+
+func (p statPredicate) String() string {
+	if p.op == "" {
+		return p.stat
+	}
+	return p.stat + p.op + strconv.FormatFloat(p.value, 'f', -1, 64)
+}
+
+func (p statPredicate) holds(v float64) bool {
+	switch p.op {
+	case "==":
+		return v == p.value
+	case "!=":
+		return v != p.value
+	case ">=":
+		return v >= p.value
+	case "<=":
+		return v <= p.value
+	case ">":
+		return v > p.value
+	case "<":
+		return v < p.value
+	default:
+		return true
+	}
+}
+
+// Stats is the subset of envoy's `/stats?format=json` output that we look at. Counters and gauges have a name and a
+// value, while histograms are grouped together in a single entry.
+type Stats struct {
+	Stats []struct {
+		Name       string      `json:"name"`
+		Value      interface{} `json:"value"`
+		Histograms *struct {
+			ComputedQuantiles []struct {
+				Name   string `json:"name"`
+				Values []struct {
+					Cumulative interface{} `json:"cumulative"`
+				} `json:"values"`
+			} `json:"computed_quantiles"`
+		} `json:"histograms"`
+	} `json:"stats"`
+}
+
+// values returns the value of each counter and gauge. Histograms which have recorded samples are given the largest of
+// their cumulative quantiles, which is the maximum sample.
+func (s *Stats) values() map[string]float64 {
+	values := map[string]float64{}
+	for _, stat := range s.Stats {
+		if stat.Histograms != nil {
+			for _, h := range stat.Histograms.ComputedQuantiles {
+				for _, q := range h.Values {
+					v, ok := q.Cumulative.(float64)
+					if max, seen := values[h.Name]; ok && (!seen || v > max) {
+						values[h.Name] = v
+					}
+				}
+			}
+			continue
+		}
+		if v, ok := stat.Value.(float64); ok {
+			values[stat.Name] = v
+		}
+	}
+	return values
+}
+
+// fetchStats gets the current value of the named stats.
+func fetchStats(ctx context.Context, admin *adminClient, host string, names []string) (map[string]float64, error) {
+	patterns := make([]string, len(names))
+	for i, name := range names {
+		patterns[i] = regexp.QuoteMeta(name)
+	}
+	return fetchStatsMatching(ctx, admin, host, fmt.Sprintf("^(%s)$", strings.Join(patterns, "|")))
+}
+
+// fetchStatsMatching gets the current value of the stats whose names match the regular expression filter.
+func fetchStatsMatching(ctx context.Context, admin *adminClient, host, filter string) (map[string]float64, error) {
+	query := url.Values{
+		"format": {"json"},
+		"filter": {filter},
+	}
+
+	stats := &Stats{}
+	if err := admin.get(ctx, fmt.Sprintf("%s/stats?%s", host, query.Encode()), stats); err != nil {
+		return nil, err
+	}
+	return stats.values(), nil
+}
+
+// statsHold checks that every predicate holds against envoy's current stats.
+func statsHold(admin *adminClient, host string, predicates []statPredicate) readinessCheck {
+	names := make([]string, len(predicates))
+	for i, p := range predicates {
+		names[i] = p.stat
+	}
+
+	return func(ctx context.Context) error {
+		values, err := fetchStats(ctx, admin, host, names)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range predicates {
+			v, ok := values[p.stat]
+			if !ok {
+				return fmt.Errorf("stat %q not recorded yet", p.stat)
+			}
+			if !p.holds(v) {
+				return fmt.Errorf("stat %q is %v, want %s", p.stat, v, p)
+			}
+		}
+		return nil
+	}
+}
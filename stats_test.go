@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatPredicates(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []statPredicate
+	}{
+		{"", nil},
+		{"server.live", []statPredicate{{stat: "server.live"}}},
+		{"a==1", []statPredicate{{stat: "a", op: "==", value: 1}}},
+		{"a!=0", []statPredicate{{stat: "a", op: "!=", value: 0}}},
+		// Two-character operators mustn't be taken for their one-character prefixes
+		{"a>=2", []statPredicate{{stat: "a", op: ">=", value: 2}}},
+		{"a<=2", []statPredicate{{stat: "a", op: "<=", value: 2}}},
+		{"a>2", []statPredicate{{stat: "a", op: ">", value: 2}}},
+		{"a<2.5", []statPredicate{{stat: "a", op: "<", value: 2.5}}},
+		{" a >= -1 , b ", []statPredicate{{stat: "a", op: ">=", value: -1}, {stat: "b"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseStatPredicates(tt.s)
+		if err != nil {
+			t.Errorf("parseStatPredicates(%q) returned error: %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatPredicates(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatPredicatesErrors(t *testing.T) {
+	for _, s := range []string{"a==", "a>=x", "==1", "a=1", "a<>1", "a>>1"} {
+		if _, err := parseStatPredicates(s); err == nil {
+			t.Errorf("parseStatPredicates(%q) didn't return an error", s)
+		}
+	}
+}
+
+func TestStatPredicateHolds(t *testing.T) {
+	tests := []struct {
+		p    statPredicate
+		v    float64
+		want bool
+	}{
+		{statPredicate{stat: "a"}, 0, true},
+		{statPredicate{stat: "a", op: "==", value: 1}, 1, true},
+		{statPredicate{stat: "a", op: "==", value: 1}, 2, false},
+		{statPredicate{stat: "a", op: ">=", value: 1}, 1, true},
+		{statPredicate{stat: "a", op: ">", value: 1}, 1, false},
+		{statPredicate{stat: "a", op: "<", value: 1}, 0, true},
+	}
+	for _, tt := range tests {
+		if got := tt.p.holds(tt.v); got != tt.want {
+			t.Errorf("%s holds for %v = %t, want %t", tt.p, tt.v, got, tt.want)
+		}
+	}
+}
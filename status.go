@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// exitStatus describes how a process exited: either with an exit code, or killed by a signal. As in a shell, the code
+// of a process killed by a signal is 128+signal.
+type exitStatus struct {
+	code       int
+	signal     syscall.Signal
+	coreDumped bool
+}
+
+func newExitStatus(ws syscall.WaitStatus) exitStatus {
+	if ws.Signaled() {
+		return signalledStatus(ws.Signal(), ws.CoreDump())
+	}
+	return exitStatus{code: ws.ExitStatus()}
+}
+
+func signalledStatus(sig syscall.Signal, coreDumped bool) exitStatus {
+	return exitStatus{code: 128 + int(sig), signal: sig, coreDumped: coreDumped}
+}
+
+func (s exitStatus) success() bool {
+	return s.signal == 0 && s.code == 0
+}
+
+func (s exitStatus) String() string {
+	if s.signal != 0 {
+		return "killed by " + signalName(s.signal)
+	}
+	return "exited with code " + strconv.Itoa(s.code)
+}
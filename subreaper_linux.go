@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+const prSetChildSubreaper = 36 // PR_SET_CHILD_SUBREAPER, from linux/prctl.h
+
+// becomeSubreaper makes orphaned descendants get reparented to us rather than to PID 1, so that we can reap them.
+func becomeSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
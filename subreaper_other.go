@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// becomeSubreaper is only supported on Linux. Elsewhere, only orphans reparented to us as PID 1 can be reaped.
+func becomeSubreaper() error {
+	return errors.New("subreapers are only supported on Linux")
+}
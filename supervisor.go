@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cenk/backoff"
+)
+
+// supervisor runs the application's processes alongside envoy. It passes signals on to the processes and restarts them
+// if it should. Once they have exited for good, it decides whether envoy should be shut down before we exit.
+type supervisor struct {
+	cfg     *config
+	admin   *adminClient
+	checker ReadinessChecker
+	local   bool   // whether envoy is on this machine, so that we may fail its health checks and shut it down
+	killAPI string // empty if there's no way to tell this kind of sidecar to exit
+	reap    *reaper
+	envoy   *envoyProcess // set if we started envoy ourselves, with ENVOY_BINARY
+
+	specs        []processSpec
+	exitCodeFrom string
+	last         exitStatus // how the processes exited the last time they ran
+
+	// Cancelled when we're stopped while the processes aren't running
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	running   map[int]*os.Process // by index into specs, nil while the processes aren't running
+	interrupt syscall.Signal      // the signal which stopped us while the processes weren't running
+	stopping  bool                // the processes have been told to terminate, so they mustn't be restarted
+	delaying  bool                // a SIGTERM is being held back by PREFLIGHT_TERM_DELAY
+	killTimer *time.Timer         // kills the processes once PREFLIGHT_KILL_GRACE is up
+	envoyLost int                 // the exit code to report once envoy has died or failed the watchdog
+	exiting   bool                // we're on our way out, so envoy exiting is expected
+}
+
+func newSupervisor(cfg *config, admin *adminClient, checker ReadinessChecker, local bool, killAPI string, reap *reaper, specs []processSpec, exitCodeFrom string) *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &supervisor{
+		cfg:          cfg,
+		admin:        admin,
+		checker:      checker,
+		local:        local,
+		killAPI:      killAPI,
+		reap:         reap,
+		specs:        specs,
+		exitCodeFrom: exitCodeFrom,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// handleSignals passes signals on to the processes while they're running, and otherwise stops us if a signal means
+// that we should stop.
+func (s *supervisor) handleSignals() {
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop)
+	go func() {
+		for sig := range stop {
+			s.signalled(sig.(syscall.Signal))
+		}
+	}()
+}
+
+func (s *supervisor) signalled(sig syscall.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.running == nil:
+		// Signal received while nothing is running. Stop waiting for envoy and exit.
+		if stopsStartup(sig) && s.interrupt == 0 {
+			s.interrupt = sig
+			s.cancel()
+		}
+	case !s.cfg.signals.allowed(sig):
+		// Not meant for the application, so ignore it
+	case sig == syscall.SIGTERM && s.cfg.termDelay > 0:
+		if !s.delaying {
+			s.delaying, s.stopping = true, true
+			go s.delayTerm()
+		}
+	default:
+		s.forward(sig)
+	}
+}
+
+// forward passes sig on to the running processes. s.mu must be held.
+func (s *supervisor) forward(sig syscall.Signal) {
+	for _, proc := range s.running {
+		signalProcess(proc, s.cfg.processGroup, s.cfg.signals.mapped(sig))
+	}
+	if sig != syscall.SIGTERM && sig != syscall.SIGINT {
+		return
+	}
+
+	s.stopping = true
+	// If the processes ignore being told to terminate, kill them once the grace period is up
+	if s.cfg.killGrace > 0 && s.killTimer == nil {
+		s.killTimer = time.AfterFunc(s.cfg.killGrace, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, proc := range s.running {
+				if signalProcess(proc, s.cfg.processGroup, syscall.SIGKILL) == nil {
+					log.Printf("application still running %s after %s, killed it", s.cfg.killGrace, signalName(sig))
+				}
+			}
+		})
+	}
+}
+
+// delayTerm takes envoy out of load balancing, giving everything routing to us time to notice before the
+// application is terminated
+func (s *supervisor) delayTerm() {
+	log.Printf("received SIGTERM, forwarding it to the application in %s", s.cfg.termDelay)
+	time.AfterFunc(s.cfg.termDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.forward(syscall.SIGTERM)
+	})
+
+	if f, canFail := s.checker.(healthFailer); s.local && canFail {
+		if err := f.FailHealthCheck(context.Background()); err != nil {
+			log.Printf("failed to fail envoy's health check: %v", err)
+		}
+	}
+}
+
+// lose stops the processes because envoy has died or is unhealthy, so that we exit with exitCode. It reports whether
+// this is news, rather than envoy having been lost already or us exiting anyway.
+func (s *supervisor) lose(exitCode int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lost(exitCode)
+}
+
+// lost is lose with s.mu held.
+func (s *supervisor) lost(exitCode int) bool {
+	if s.envoyLost != 0 || s.exiting {
+		return false
+	}
+	s.envoyLost = exitCode
+	if s.running != nil {
+		s.forward(syscall.SIGTERM)
+	} else {
+		s.cancel()
+	}
+	return true
+}
+
+// watchdogFailed takes ENVOY_WATCHDOG_ACTION. Once the processes are being terminated the failure is ignored, as envoy
+// may have been told to fail its health checks, and the termination is already under way.
+func (s *supervisor) watchdogFailed(action watchdogAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if action.kind == watchdogLog {
+		return
+	}
+	if s.stopping {
+		log.Printf("ignoring the failure, as the application is being terminated")
+		return
+	}
+	switch action.kind {
+	case watchdogSignal:
+		// The signal is sent as it is, without being mapped or filtered
+		for _, proc := range s.running {
+			signalProcess(proc, s.cfg.processGroup, action.signal)
+		}
+	case watchdogExit:
+		s.lost(exitEnvoyUnhealthy)
+	}
+}
+
+// startEnvoy starts envoy from ENVOY_BINARY. An envoy we started dying is like the watchdog giving up on it: the
+// application can't work without it.
+func (s *supervisor) startEnvoy() {
+	envoy, err := startEnvoy(s.cfg, s.reap)
+	if err != nil {
+		log.Printf("failed to start envoy: %v", err)
+		os.Exit(exitUnavailable)
+	}
+	s.envoy = envoy
+
+	go func() {
+		<-envoy.exited
+		if s.lose(exitUnavailable) {
+			log.Printf("envoy %s", envoy.status)
+		}
+	}()
+}
+
+// stopEnvoy makes sure that envoy has exited if we started it, as it mustn't outlive us
+func (s *supervisor) stopEnvoy() {
+	if s.envoy != nil {
+		s.envoy.stop(s.cfg.killTimeout)
+	}
+}
+
+// waitFor blocks until every check passes, or we're stopped. If we give up waiting, we exit.
+func (s *supervisor) waitFor(what string, checks ...readinessCheck) {
+	if !wait(s.ctx, s.cfg, what, checks...) {
+		s.stopEnvoy()
+		os.Exit(exitUnavailable)
+	}
+}
+
+// exit shuts envoy down if killEnvoy is set, and then exits with exitCode
+func (s *supervisor) exit(status exitStatus, exitCode int, killEnvoy bool) {
+	s.mu.Lock()
+	s.exiting = true
+	died := s.envoyLost == exitUnavailable
+	s.mu.Unlock()
+
+	switch {
+	case died:
+		// There's no point asking an envoy we started to exit once it has died
+	case !s.local:
+		// There's no local envoy; do nothing
+	case s.killAPI == "":
+		// There's no way to tell this kind of sidecar to exit, do nothing
+	case !killEnvoy:
+		// We're configured not to kill envoy when the application exits like this, do nothing
+	default:
+		err := shutdown(context.Background(), s.admin, s.checker, s.killAPI, s.cfg.drainTimeout, s.cfg.killTimeout)
+		if err != nil {
+			log.Printf("could not confirm that envoy exited: %v", err)
+			// Don't mask a failure of the application itself
+			if exitCode == 0 {
+				exitCode = exitEnvoyRunning
+			}
+		}
+	}
+
+	s.stopEnvoy()
+
+	// Die the way the application did, unless we have a failure of our own to report
+	if s.cfg.exitSignal && status.signal != 0 && exitCode == status.code {
+		raise(status.signal)
+	}
+	os.Exit(exitCode)
+}
+
+// interrupted exits because we were stopped while the processes weren't running. If we were signalled the pod is going
+// away, so envoy is shut down too unless it should never be.
+func (s *supervisor) interrupted() {
+	s.mu.Lock()
+	lost, interrupt := s.envoyLost, s.interrupt
+	s.mu.Unlock()
+	if lost != 0 {
+		// An unhealthy envoy may well still be running, so it's shut down as it would have been when the processes
+		// last exited
+		s.exit(s.last, lost, s.cfg.killPolicy.matches(s.last))
+	}
+
+	log.Printf("received %s before starting the application", signalName(interrupt))
+	status := signalledStatus(interrupt, false)
+	s.exit(status, status.code, !s.cfg.killPolicy.never)
+}
+
+// idle looks after an envoy we started when there are no processes to run, until we're told to stop or it exits.
+func (s *supervisor) idle() {
+	<-s.ctx.Done()
+	s.interrupted()
+}
+
+// supervise runs the processes, restarting them as PREFLIGHT_RESTART says, and then exits. Before each restart envoy
+// must pass ready, if it's set.
+func (s *supervisor) supervise(ready readinessCheck) {
+	binaries := make([]string, len(s.specs))
+	for i, spec := range s.specs {
+		var err error
+		if binaries[i], err = exec.LookPath(spec.Command[0]); err != nil {
+			s.stopEnvoy()
+			panic(err)
+		}
+	}
+
+	// Backs off between restarts, starting again once the application has managed to keep running for a while
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
+		status := s.run(binaries)
+		s.last = status
+
+		// Signals which arrive before the application is restarted stop us restarting it
+		s.mu.Lock()
+		lost := s.envoyLost
+		restart := lost == 0 && !s.stopping && shouldRestart(s.cfg.restart, s.cfg.maxRestarts, restarts, status)
+		if restart {
+			s.running = nil
+		}
+		s.mu.Unlock()
+		if lost != 0 {
+			log.Printf("application exited after envoy failed")
+			s.exit(status, lost, s.cfg.killPolicy.matches(status))
+		}
+		if !restart {
+			s.exit(status, status.code, s.cfg.killPolicy.matches(status))
+		}
+
+		if time.Since(started) > b.MaxInterval {
+			b.Reset()
+		}
+		delay := b.NextBackOff()
+		log.Printf("application %s, restarting it in %s (restart %d)", status, delay.Round(time.Millisecond), restarts+1)
+		select {
+		case <-s.ctx.Done():
+			s.interrupted()
+		case <-time.After(delay):
+		}
+		if ready != nil {
+			s.waitFor("envoy", ready)
+		}
+	}
+}
+
+// run starts every process, and waits for them all to exit. A required process exiting terminates the others. It
+// returns the status of the process named by exit_code_from, or else of the process which ended the run.
+func (s *supervisor) run(binaries []string) exitStatus {
+	type processExit struct {
+		i  int
+		ws syscall.WaitStatus
+	}
+	exits := make(chan processExit, len(s.specs))
+
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		s.interrupted()
+	}
+	s.running = map[int]*os.Process{}
+	for i, spec := range s.specs {
+		proc, done, err := s.reap.start(binaries[i], spec.Command, &os.ProcAttr{
+			Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+			Sys:   sysProcAttr(s.cfg.processGroup),
+		})
+		if err != nil {
+			s.forward(syscall.SIGKILL)
+			s.mu.Unlock()
+			s.stopEnvoy()
+			panic(err)
+		}
+		s.running[i] = proc
+		go func(i int) {
+			exits <- processExit{i: i, ws: <-done}
+		}(i)
+	}
+	s.mu.Unlock()
+
+	var status, ending exitStatus
+	ended := false
+	for range s.specs {
+		e := <-exits
+		spec := s.specs[e.i]
+
+		s.mu.Lock()
+		proc := s.running[e.i]
+		delete(s.running, e.i)
+		s.mu.Unlock()
+
+		if killStragglers(proc, s.cfg.processGroup) {
+			log.Printf("killed processes left behind by %s", spec.Name)
+		}
+		exited := newExitStatus(e.ws)
+		if exited.coreDumped {
+			log.Printf("%s was killed by %s and dumped core", spec.Name, signalName(exited.signal))
+		}
+		if len(s.specs) > 1 {
+			log.Printf("%s %s", spec.Name, exited)
+		}
+		if spec.Name == s.exitCodeFrom {
+			status = exited
+		}
+
+		s.mu.Lock()
+		if !ended && (spec.required() || len(s.running) == 0) {
+			ended, ending = true, exited
+			if len(s.running) > 0 {
+				log.Printf("%s has exited, terminating the other processes", spec.Name)
+				s.forward(syscall.SIGTERM)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	if s.exitCodeFrom == "" {
+		status = ending
+	}
+	return status
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// What the watchdog does once envoy has failed too many checks in a row, set with ENVOY_WATCHDOG_ACTION.
+const (
+	watchdogLog    = "log"    // only log it
+	watchdogSignal = "signal" // send a signal to the application
+	watchdogExit   = "exit"   // terminate the application and exit with exitEnvoyUnhealthy
+)
+
+type watchdogAction struct {
+	kind   string
+	signal syscall.Signal
+}
+
+// parseWatchdogAction parses `log`, `exit` or `signal:SIG`, e.g. `signal:SIGHUP`. A bare `signal` sends SIGTERM.
+func parseWatchdogAction(s string) (watchdogAction, error) {
+	kind, sig := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, sig = s[:i], s[i+1:]
+	}
+	switch {
+	case kind == watchdogSignal && sig == "":
+		return watchdogAction{kind: kind, signal: syscall.SIGTERM}, nil
+	case kind == watchdogSignal:
+		signal, err := parseSignal(sig)
+		if err != nil {
+			return watchdogAction{}, err
+		}
+		return watchdogAction{kind: kind, signal: signal}, nil
+	case (kind == watchdogLog || kind == watchdogExit) && sig == "":
+		return watchdogAction{kind: kind}, nil
+	}
+	return watchdogAction{}, fmt.Errorf("unknown action %q", s)
+}
+
+// watchdog runs check every interval, forever. Once it has failed failures times in a row, fail is called; it isn't
+// called again until check has passed at least once.
+func watchdog(interval time.Duration, failures int, check readinessCheck, fail func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failed := 0
+	for range ticker.C {
+		err := check(context.Background())
+		switch {
+		case err == nil:
+			if failed >= failures {
+				log.Printf("envoy is live again")
+			}
+			failed = 0
+		default:
+			failed++
+			if failed == failures {
+				log.Printf("envoy has failed %d checks in a row: %v", failed, err)
+				fail(err)
+			}
+		}
+	}
+}